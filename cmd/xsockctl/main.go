@@ -0,0 +1,282 @@
+// xsockctl talks to an xsock.Server
+//
+//  xsockctl [flags] <address> [message...]
+//
+// With a message it is sent once, the reply printed and the exit code
+// of the reply (session byte) becomes the exit status. Without one it
+// reads messages from stdin, one per line (REPL).
+//
+// Exit status
+//  0 .. 3  - reply exit code, xsock.ExitOK, ExitFail, ExitInvalid, ExitRejected
+//  64      - usage
+//  69      - connection or transport error
+//
+// REPL built-ins
+//  history     - list previous messages
+//  !!          - repeat last message
+//  !N          - repeat message N from history
+//  quit, exit  - close the session and leave
+package main
+
+import (
+    "bufio"
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/hex"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+    "vella/v2utils/xsock"
+)
+
+const (
+    // exit status of local errors, outside
+    // of reply exit codes (sysexits.h)
+    exitUsage   = 64
+    exitConn    = 69
+
+    prompt      = "xsock> "
+    histFile    = ".xsockctl_history"
+    histMax     = 1000
+)
+
+var (
+    flagHex     = flag.Bool("hex", false, "dump raw packets (hex) to stderr")
+    flagTimeout = flag.Duration("timeout", 3*time.Second, "per message timeout")
+    flagPersist = flag.Bool("persistent", false, "keep one connection open")
+    flagHist    = flag.String("history", "", "history file (default ~/" + histFile + ")")
+    flagCA      = flag.String("ca", "", "CA certificate (PEM) for tls:// addresses")
+    flagCert    = flag.String("cert", "", "client certificate (PEM) for mutual TLS")
+    flagKey     = flag.String("key", "", "client key (PEM) for mutual TLS")
+)
+
+func main() {
+    flag.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s [flags] <address> [message...]\n", os.Args[0])
+        fmt.Fprintf(os.Stderr, "address: /path/x.sock, unix:///path/x.sock, tcp://host:port, tls://host:port\n")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+
+    if flag.NArg() < 1 {
+        flag.Usage()
+        os.Exit(exitUsage)
+    }
+
+    c, err := connect(flag.Arg(0))
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(exitConn)
+    }
+    defer c.Close()
+
+    if flag.NArg() > 1 {
+        code, err := send(c, strings.Join(flag.Args()[1:], " "))
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            c.Close()
+            os.Exit(exitConn)
+        }
+
+        c.Close()
+        os.Exit(int(code))
+    }
+
+    repl(c)
+}
+
+func connect(addr string) (*xsock.Client, error) {
+    var trace func(bool, *xsock.Packet)
+    if *flagHex {
+        trace = dump
+    }
+
+    var conf *tls.Config
+    if strings.HasPrefix(addr, "tls://") {
+        var err error
+        if conf, err = tlsConfig(); err != nil {
+            return nil, err
+        }
+    }
+
+    return xsock.NewClient(addr,
+        xsock.ClientTimeout(*flagTimeout),
+        xsock.Persistent(*flagPersist),
+        xsock.Resyn(true),
+        xsock.ClientTrace(trace),
+        xsock.ClientTLS(conf),
+    )
+}
+
+func tlsConfig() (*tls.Config, error) {
+    conf := &tls.Config{}
+
+    if *flagCA != "" {
+        pem, err := os.ReadFile(*flagCA)
+        if err != nil {
+            return nil, err
+        }
+
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, errors.New("no certificates in " + *flagCA)
+        }
+
+        conf.RootCAs = pool
+    }
+
+    if *flagCert != "" {
+        cert, err := tls.LoadX509KeyPair(*flagCert, *flagKey)
+        if err != nil {
+            return nil, err
+        }
+
+        conf.Certificates = []tls.Certificate{cert}
+    }
+
+    return conf, nil
+}
+
+func send(c *xsock.Client, msg string) (uint8, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), *flagTimeout)
+    defer cancel()
+
+    reply, code, err := c.Exec(ctx, msg)
+    if err != nil {
+        return 0, err
+    }
+
+    fmt.Println(reply)
+    return code, nil
+}
+
+func repl(c *xsock.Client) {
+    hist := loadHistory()
+    defer func() { saveHistory(hist) }()
+
+    r := bufio.NewScanner(os.Stdin)
+    for {
+        fmt.Print(prompt)
+        if !r.Scan() {
+            fmt.Println()
+            return
+        }
+
+        line := strings.TrimSpace(r.Text())
+
+        switch ; {
+            case line == "":
+                continue
+
+            case line == "quit" || line == "exit":
+                return
+
+            case line == "history":
+                for i, h := range hist {
+                    fmt.Printf("%5d  %s\n", i+1, h)
+                }
+                continue
+
+            case strings.HasPrefix(line, "!"):
+                h, err := recall(hist, line)
+                if err != nil {
+                    fmt.Fprintln(os.Stderr, err)
+                    continue
+                }
+
+                fmt.Println(h)
+                line = h
+        }
+
+        hist = append(hist, line)
+
+        code, err := send(c, line)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            continue
+        }
+
+        if code != xsock.ExitOK {
+            fmt.Printf("(exit %d)\n", code)
+        }
+    }
+}
+
+func recall(hist []string, line string) (string, error) {
+    if len(hist) == 0 {
+        return "", errors.New("history is empty")
+    }
+
+    if line == "!!" {
+        return hist[len(hist)-1], nil
+    }
+
+    n, err := strconv.Atoi(line[1:])
+    if err != nil || n < 1 || n > len(hist) {
+        return "", errors.New("no such history entry: " + line)
+    }
+
+    return hist[n-1], nil
+}
+
+func historyPath() string {
+    if *flagHist != "" {
+        return *flagHist
+    }
+
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return ""
+    }
+
+    return filepath.Join(home, histFile)
+}
+
+func loadHistory() []string {
+    path := historyPath()
+    if path == "" {
+        return nil
+    }
+
+    b, err := os.ReadFile(path)
+    if err != nil {
+        return nil
+    }
+
+    var hist []string
+    for _, l := range strings.Split(string(b), "\n") {
+        if l != "" {
+            hist = append(hist, l)
+        }
+    }
+
+    return hist
+}
+
+func saveHistory(hist []string) {
+    path := historyPath()
+    if path == "" {
+        return
+    }
+
+    if len(hist) > histMax {
+        hist = hist[len(hist)-histMax:]
+    }
+
+    os.WriteFile(path, []byte(strings.Join(hist, "\n") + "\n"), 0600)
+}
+
+func dump(out bool, p *xsock.Packet) {
+    dir := "<<"
+    if out {
+        dir = ">>"
+    }
+
+    fmt.Fprintf(os.Stderr, "%s packet\n%s", dir, hex.Dump(p.Stream[:]))
+}
@@ -13,15 +13,25 @@ func NewError() *Error {
 }
 
 func (e *Error) Error() string {
-    if e.ctx == "" {
-        e.ctx = "v2utils"
+    ctx := e.ctx
+    if ctx == "" {
+        ctx = "v2utils"
     }
 
-    return fmt.Sprintf("%s: %s", e.ctx, e.err)
+    return fmt.Sprintf("%s: %s", ctx, e.err)
 }
 
+// package level errors are shared (between goroutines too),
+// Ctx() hands out a copy instead of changing the original,
+// use errors.Is() to match the copy against the original
+
 func (e *Error) Err(r string) *Error { e.err = r; return e }
-func (e *Error) Ctx(c string) *Error { e.ctx = c; return e }
+func (e *Error) Ctx(c string) *Error { r := *e; r.ctx = c; return &r }
+
+func (e *Error) Is(target error) bool {
+    t, ok := target.(*Error)
+    return ok && t.err == e.err
+}
 
 func Err(e string)       *Error { return NewError().Err(e) }
 func ErrCtx(e, c string) *Error { return NewError().Err(e).Ctx(c) }
@@ -0,0 +1,210 @@
+package fileops
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+const (
+    // fingerprint, first bytes of the file
+    fp_len = 1024
+
+    // milliseconds, state file writes
+    cp_zzzZZzz = 1000
+)
+
+// Checkpoint keeps offset of every tailed path in a state file,
+// noted after each chunk the consumer took and written out at most
+// every cp_zzzZZzz, when Tail goes idle and when it stops (after
+// a crash some may come again). On start Tail resumes from there
+// if path is still the same file (inode + fingerprint), if it was
+// rotated meanwhile the rotated file is read to the end first and
+// the new one from the start. State file that does not parse
+// (crashed mid way) is started over.
+// Tails may share the state file.
+func Checkpoint(file string) func(*fileTail) {
+    cs := checkpointStore(file)
+    return func(ft *fileTail) {
+        ft.cp = cs
+    }
+}
+
+type checkpoint struct {
+    Ino uint64 `json:"ino"`
+    Fp string `json:"fp"`
+    FpLen int `json:"fp_len"`
+    Offset int64 `json:"offset"`
+}
+
+type checkpoints struct {
+    file string
+    mu sync.Mutex
+    m map[string]checkpoint // nil until loaded
+    dirty bool
+    written time.Time
+}
+// one store per state file,
+// tails sharing it share the lock
+var stores = struct {
+    sync.Mutex
+    m map[string]*checkpoints
+}{m: make(map[string]*checkpoints)}
+
+func checkpointStore(file string) *checkpoints {
+    file = filepath.Clean(file)
+    stores.Lock()
+    defer stores.Unlock()
+    cs, ok := stores.m[file]
+    if !ok {
+        cs = &checkpoints{file: file}
+        stores.m[file] = cs
+    }
+    return cs
+}
+// load reads the state file once, lock held
+func (cs *checkpoints) load() error {
+    if cs.m != nil {
+        return nil
+    }
+    m := make(map[string]checkpoint)
+    b, err := os.ReadFile(cs.file)
+    if err != nil && ! errors.Is(err, fs.ErrNotExist) {
+        return err
+    }
+    if len(b) > 0 && json.Unmarshal(b, &m) != nil {
+        // half written, start over
+        m = make(map[string]checkpoint)
+    }
+    cs.m = m
+    return nil
+}
+func (cs *checkpoints) get(path string) (checkpoint, bool, error) {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    if err := cs.load(); err != nil {
+        return checkpoint{}, false, err
+    }
+    cp, ok := cs.m[path]
+    return cp, ok, nil
+}
+func (cs *checkpoints) save(path string, cp checkpoint) error {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    if err := cs.load(); err != nil {
+        return err
+    }
+    cs.m[path] = cp
+    cs.dirty = true
+    if time.Since(cs.written) < time.Duration(cp_zzzZZzz) * time.Millisecond {
+        return nil
+    }
+    return cs.write()
+}
+// flush writes what was saved meanwhile, nil store does nothing
+func (cs *checkpoints) flush() error {
+    if cs == nil {
+        return nil
+    }
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    if ! cs.dirty {
+        return nil
+    }
+    return cs.write()
+}
+// write syncs temp file and renames it over (and syncs
+// the directory), state file is never half written
+func (cs *checkpoints) write() error {
+    b, err := json.Marshal(cs.m)
+    if err != nil {
+        return err
+    }
+    tmp := cs.file + ".tmp"
+    fh, err := os.OpenFile(tmp, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    _, err = fh.Write(b)
+    if err == nil {
+        err = fh.Sync()
+    }
+    if e := fh.Close(); err == nil {
+        err = e
+    }
+    if err != nil {
+        return err
+    }
+    if err := os.Rename(tmp, cs.file); err != nil {
+        return err
+    }
+    dir, err := os.Open(filepath.Dir(cs.file))
+    if err != nil {
+        return err
+    }
+    defer dir.Close()
+    if err := dir.Sync(); err != nil {
+        return err
+    }
+    cs.dirty = false
+    cs.written = time.Now()
+    return nil
+}
+
+// fingerprint is hash of first n bytes of fh (less if shorter),
+// returns how many bytes it covers
+func fingerprint(fh *os.File, n int) (string, int, error) {
+    b := make([]byte, n)
+    r, err := fh.ReadAt(b, 0)
+    if err != nil && err != io.EOF {
+        return "", 0, err
+    }
+    sum := sha256.Sum256(b[:r])
+    return hex.EncodeToString(sum[:]), r, nil
+}
+// matches is true if fh starts with what cp was taken from
+func (cp checkpoint) matches(fh *os.File) bool {
+    fp, n, err := fingerprint(fh, cp.FpLen)
+    return err == nil && n == cp.FpLen && fp == cp.Fp
+}
+
+// findRotated looks next to path for the file cp was taken from
+// (path.1, path-20240101, ...), "" if it is gone
+func findRotated(path string, cp checkpoint) string {
+    return findSibling(path, cp, func(st syscall.Stat_t) bool {
+        return st.Ino == cp.Ino
+    })
+}
+func findSibling(path string, cp checkpoint, same func(syscall.Stat_t) bool) string {
+    dir, base := filepath.Split(path)
+    entries, err := os.ReadDir(filepath.Clean(dir))
+    if err != nil {
+        return ""
+    }
+    for _, e := range entries {
+        if e.Name() == base || ! strings.HasPrefix(e.Name(), base) || e.IsDir() {
+            continue
+        }
+        rotated := filepath.Join(dir, e.Name())
+        if st, err := stat(rotated); err != nil || ! same(st) {
+            continue
+        }
+        fh, err := os.Open(rotated)
+        if err != nil {
+            continue
+        }
+        ok := cp.matches(fh)
+        fh.Close()
+        if ok {
+            return rotated
+        }
+    }
+    return ""
+}
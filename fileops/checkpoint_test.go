@@ -0,0 +1,71 @@
+package fileops
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestCheckpointResume(t *testing.T) {
+    dir := t.TempDir()
+    p := filepath.Join(dir, "log")
+    st := filepath.Join(dir, "state")
+    writeFile(t, p, "a\n")
+
+    ft := NewTail(p, Checkpoint(st), Lines(true))
+    time.Sleep(50 * time.Millisecond)
+
+    f := appender(t, p)
+    write(t, f, "b\nc\n")
+    expect(t, until(t, ft, "c"), note{File_std, "b"}, note{File_std, "c"})
+    ft.Stop()
+
+    // written while down
+
+    write(t, f, "d\n")
+
+    ft = NewTail(p, Checkpoint(st), Lines(true))
+    expect(t, until(t, ft, "d"), note{File_std, "d"})
+    ft.Stop()
+
+    // rotated while down, rest of the old file comes first
+
+    write(t, f, "e\n")
+    if err := os.Rename(p, p+".1"); err != nil {
+        t.Fatal(err)
+    }
+    writeFile(t, p, "x\ny\n")
+
+    ft = NewTail(p, Checkpoint(st), Lines(true))
+    expect(t, until(t, ft, "y"), note{File_drn, "e"}, note{File_new, "x"}, note{File_std, "y"})
+    ft.Stop()
+}
+
+// unreadable state starts over, Stop() writes it whole
+
+func TestCheckpointCorrupt(t *testing.T) {
+    dir := t.TempDir()
+    p := filepath.Join(dir, "log")
+    st := filepath.Join(dir, "state")
+    writeFile(t, p, "a\n")
+    writeFile(t, st, "{trunc")
+
+    ft := NewTail(p, Checkpoint(st), Lines(true))
+    time.Sleep(50 * time.Millisecond)
+
+    write(t, appender(t, p), "b\n")
+    expect(t, until(t, ft, "b"), note{File_std, "b"})
+    ft.Stop()
+
+    b, err := os.ReadFile(st)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var m map[string]json.RawMessage
+    if err := json.Unmarshal(b, &m); err != nil || len(m) != 1 {
+        t.Fatalf("state %q: %v", b, err)
+    }
+}
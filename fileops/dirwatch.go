@@ -0,0 +1,273 @@
+package fileops
+import (
+    "context"
+    "fmt"
+    "io/fs"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+const (
+    // milliseconds
+    dir_zzzZZzz = 1000
+)
+
+// DirEvent is a change of a file under the watched tree
+//  File_new - created (or moved into the tree)
+//  File_chg - modified
+//  File_mis - deleted (or moved out of the tree)
+//  File_mov - renamed, OldPath() is where it was
+// File that cannot be looked at (no access, symlink loop) is
+// left out, its File_err comes once and the watcher goes on.
+type DirEvent struct {
+    path, old string
+    status uint8
+}
+func (de DirEvent) Path() string { return de.path }
+func (de DirEvent) OldPath() string { return de.old }
+func (de DirEvent) Status() uint8 { return de.status }
+func (de DirEvent) Data() any { return de.old }
+
+type fileState struct {
+    ino uint64
+    size, mtime, ctime int64 // nanoseconds
+}
+
+type dirWatch struct {
+    root string
+    include, exclude []string
+    poll bool
+    files map[string]fileState // known files
+    bad map[string]bool // files with stat error told already
+    errs []Notify // waiting to be told
+    comms chan Notify
+    runner
+}
+
+// Config
+// Patterns are filepath.Match globs, matched against the name
+// or, if pattern has a "/", the path relative to root.
+// Excluded directories are skipped as a whole, include
+// applies to files only (none is everything).
+type DirConf func(*dirWatch)
+func Include(patterns ...string) func(*dirWatch) {
+    checkPatterns(patterns)
+    return func(dw *dirWatch) {
+        dw.include = append(dw.include, patterns...)
+    }
+}
+func Exclude(patterns ...string) func(*dirWatch) {
+    checkPatterns(patterns)
+    return func(dw *dirWatch) {
+        dw.exclude = append(dw.exclude, patterns...)
+    }
+}
+// DirPoll scans the tree every dir_zzzZZzz instead of inotify
+func DirPoll(b bool) func(*dirWatch) {
+    return func(dw *dirWatch) {
+        dw.poll = b
+    }
+}
+func checkPatterns(patterns []string) {
+    for _, p := range patterns {
+        if _, err := filepath.Match(p, ""); err != nil {
+            panic(fmt.Sprintf("Invalid pattern: %s", p))
+        }
+    }
+}
+
+func (dw *dirWatch) Path() string { return dw.root }
+func (dw *dirWatch) Ino() uint64 {
+    stat, _ := stat(dw.root)
+    return stat.Ino
+}
+func (dw *dirWatch) Comms() chan Notify { return dw.comms }
+func (dw *dirWatch) Exists() bool { return dw.Ino() != 0 }
+
+func (dw *dirWatch) match(patterns []string, path string) bool {
+    rel, err := filepath.Rel(dw.root, path)
+    if err != nil {
+        return false
+    }
+    for _, p := range patterns {
+        name := filepath.Base(rel)
+        if strings.ContainsRune(p, '/') {
+            name = rel
+        }
+        if ok, _ := filepath.Match(p, name); ok {
+            return true
+        }
+    }
+    return false
+}
+func (dw *dirWatch) wanted(path string) bool {
+    if dw.match(dw.exclude, path) {
+        return false
+    }
+    return len(dw.include) == 0 || dw.match(dw.include, path)
+}
+func (dw *dirWatch) skipDir(path string) bool {
+    return path != dw.root && dw.match(dw.exclude, path)
+}
+
+// scan walks dir, calls fn on every directory it enters
+// and returns wanted files
+func (dw *dirWatch) scan(dir string, fn func(string)) map[string]fileState {
+    files := make(map[string]fileState)
+    filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            // gone in the meantime or no access
+            return nil
+        }
+        if d.IsDir() {
+            if dw.skipDir(path) {
+                return filepath.SkipDir
+            }
+            if fn != nil {
+                fn(path)
+            }
+            return nil
+        }
+        if dw.wanted(path) {
+            if st, ok := dw.stat(path); ok {
+                files[path] = st
+            }
+        }
+        return nil
+    })
+    return files
+}
+func fileStat(path string) (fileState, error) {
+    stat, err := stat(path)
+    return fileState{stat.Ino, stat.Size, stat.Mtim.Nano(), stat.Ctim.Nano()}, err
+}
+// stat is false if path cannot be looked at,
+// the error goes out with flushErrs() (once per path)
+func (dw *dirWatch) stat(path string) (fileState, bool) {
+    st, err := fileStat(path)
+    if err != nil {
+        if ! dw.bad[path] {
+            dw.bad[path] = true
+            dw.errs = append(dw.errs, fileErr{path, err})
+        }
+        return st, false
+    }
+    delete(dw.bad, path)
+    return st, true
+}
+// flushErrs is false when stopped meanwhile
+func (dw *dirWatch) flushErrs() bool {
+    for len(dw.errs) > 0 {
+        if ! dw.send(dw.comms, dw.errs[0]) {
+            return false
+        }
+        dw.errs = dw.errs[1:]
+    }
+    return true
+}
+
+// event is false when stopped meanwhile
+func (dw *dirWatch) event(status uint8, path, old string) bool {
+    return dw.send(dw.comms, DirEvent{path, old, status})
+}
+
+// diff sends what changed between known files and cur,
+// same inode under a different path is a rename
+func (dw *dirWatch) diff(cur map[string]fileState) {
+    var gone, born []string
+    for path := range dw.files {
+        if _, ok := cur[path]; !ok {
+            gone = append(gone, path)
+        }
+    }
+    for path, st := range cur {
+        old, ok := dw.files[path]
+        switch {
+            case !ok:
+                born = append(born, path)
+            case old.ino != st.ino:
+                // replaced
+                dw.event(File_new, path, "")
+            case old != st:
+                dw.event(File_chg, path, "")
+        }
+    }
+    sort.Strings(gone)
+    sort.Strings(born)
+
+    moved := make(map[uint64]string)
+    for _, path := range gone {
+        moved[dw.files[path].ino] = path
+    }
+    for _, path := range born {
+        if old, ok := moved[cur[path].ino]; ok {
+            delete(moved, cur[path].ino)
+            dw.event(File_mov, path, old)
+            continue
+        }
+        dw.event(File_new, path, "")
+    }
+    for _, path := range gone {
+        if _, ok := moved[dw.files[path].ino]; ok {
+            dw.event(File_mis, path, "")
+        }
+    }
+    dw.files = cur
+}
+func (dw *dirWatch) pollLoop() {
+    wake := poller{dw.ctx, time.Duration(dir_zzzZZzz) * time.Millisecond}
+    for dw.flushErrs() && wake.wait(0) {
+        dw.diff(dw.scan(dw.root, nil))
+    }
+}
+
+// NewDirWatcher reports changes of files under root,
+// including directories created later (see DirEvent)
+func NewDirWatcher(root string, conf ...DirConf) FileObj {
+    return NewDirWatcherContext(context.Background(), root, conf...)
+}
+// NewDirWatcherContext stops watching when ctx is done
+func NewDirWatcherContext(ctx context.Context, root string, conf ...DirConf) FileObj {
+    dw := &dirWatch{
+        root: filepath.Clean(root),
+        bad: make(map[string]bool),
+        comms: make(chan Notify),
+        runner: newRunner(ctx),
+    }
+    if ! dw.Exists() {
+        panic("No such file or directory: " + dw.root)
+    }
+    for _, dconf := range conf {
+        dconf(dw)
+    }
+
+    // watches and initial state before returning,
+    // nothing done after NewDirWatcher() is missed
+    var in *dirInotify
+    if ! dw.poll {
+        in, _ = newDirInotify(dw)
+    }
+    if in == nil {
+        dw.files = dw.scan(dw.root, nil)
+    }
+
+    go func(dw *dirWatch) {
+        defer close(dw.done)
+        defer close(dw.comms)
+        defer dw.cancel()
+        if in != nil {
+            defer in.close()
+            go func() {
+                // unblocks loop()
+                <-dw.ctx.Done()
+                in.close()
+            }()
+            in.loop()
+            return
+        }
+        dw.pollLoop()
+    }(dw)
+
+    return dw
+}
@@ -0,0 +1,269 @@
+//go:build linux
+
+package fileops
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "syscall"
+    "time"
+    "unsafe"
+)
+const (
+    in_tree = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+    // milliseconds, IN_MOVED_FROM waits for its IN_MOVED_TO
+    // (may come with the next read), moved out of the tree after
+    move_zzzZZzz = 50
+)
+
+// dirInotify has a watch on every directory of the tree
+type dirInotify struct {
+    dw *dirWatch
+    fd int
+    fh *os.File
+    dirs map[int32]string // wd -> directory
+    buf []byte
+    from map[uint32]moveFrom // cookie -> pending IN_MOVED_FROM
+    order []uint32
+}
+type moveFrom struct {
+    path string
+    dir bool
+    at time.Time
+}
+
+func newDirInotify(dw *dirWatch) (*dirInotify, error) {
+    fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+    if err != nil {
+        return nil, err
+    }
+    in := &dirInotify{dw: dw, fd: fd, dirs: make(map[int32]string), buf: make([]byte, 256*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)), from: make(map[uint32]moveFrom)}
+    in.fh = os.NewFile(uintptr(fd), "inotify")
+
+    if _, err := syscall.InotifyAddWatch(fd, dw.root, in_tree); err != nil {
+        in.fh.Close()
+        return nil, err
+    }
+    dw.files = in.addTree(dw.root)
+    return in, nil
+}
+func (in *dirInotify) close() { in.fh.Close() }
+
+// addTree watches dir and everything under it,
+// returns wanted files found there
+func (in *dirInotify) addTree(dir string) map[string]fileState {
+    return in.dw.scan(dir, func(path string) {
+        if wd, err := syscall.InotifyAddWatch(in.fd, path, in_tree); err == nil {
+            in.dirs[int32(wd)] = path
+        }
+    })
+}
+func (in *dirInotify) rmTree(dir string) {
+    for wd, path := range in.dirs {
+        if under(path, dir) {
+            syscall.InotifyRmWatch(in.fd, uint32(wd))
+            delete(in.dirs, wd)
+        }
+    }
+}
+func under(path, dir string) bool {
+    return path == dir || strings.HasPrefix(path, dir + string(filepath.Separator))
+}
+
+func (in *dirInotify) loop() {
+    for {
+        // wake up for pending moves
+        var deadline time.Time
+        if len(in.order) > 0 {
+            deadline = in.from[in.order[0]].at.Add(time.Duration(move_zzzZZzz) * time.Millisecond)
+        }
+        if ! in.dw.flushErrs() {
+            return
+        }
+        in.fh.SetReadDeadline(deadline)
+        n, err := in.fh.Read(in.buf)
+        switch {
+            case errors.Is(err, os.ErrDeadlineExceeded):
+            case err != nil:
+                return
+            default:
+                in.events(in.buf[:n])
+        }
+        in.movedOut()
+    }
+}
+// movedOut removes what was moved from and
+// did not show up anywhere within move_zzzZZzz
+func (in *dirInotify) movedOut() {
+    wait := time.Duration(move_zzzZZzz) * time.Millisecond
+    for len(in.order) > 0 {
+        cookie := in.order[0]
+        m, ok := in.from[cookie]
+        if ok && time.Since(m.at) < wait {
+            return
+        }
+        in.order = in.order[1:]
+        if ok {
+            delete(in.from, cookie)
+            in.remove(m.path, m.dir)
+        }
+    }
+}
+func (in *dirInotify) events(b []byte) {
+    dw := in.dw
+    from := in.from
+
+    for off := 0; off+syscall.SizeofInotifyEvent <= len(b); {
+        ev := (*syscall.InotifyEvent)(unsafe.Pointer(&b[off]))
+        name := strings.TrimRight(string(b[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]), "\x00")
+        off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+        if ev.Mask & syscall.IN_Q_OVERFLOW != 0 {
+            // lost events, start over
+            in.rmTree(dw.root)
+            dw.diff(in.addTree(dw.root))
+            from = make(map[uint32]moveFrom)
+            in.from, in.order = from, nil
+            continue
+        }
+        if ev.Mask & syscall.IN_IGNORED != 0 {
+            delete(in.dirs, ev.Wd)
+            continue
+        }
+        dir, ok := in.dirs[ev.Wd]
+        if !ok {
+            continue
+        }
+        path := filepath.Join(dir, name)
+        isDir := ev.Mask & syscall.IN_ISDIR != 0
+
+        switch {
+            case ev.Mask & (syscall.IN_CREATE | syscall.IN_MOVED_TO) != 0:
+                if m, ok := from[ev.Cookie]; ok && ev.Mask & syscall.IN_MOVED_TO != 0 {
+                    delete(from, ev.Cookie)
+                    in.move(m.path, path, isDir)
+                    continue
+                }
+                in.create(path, isDir)
+            case ev.Mask & syscall.IN_MODIFY != 0:
+                if _, ok := dw.files[path]; ok {
+                    if st, ok := dw.stat(path); ok {
+                        dw.files[path] = st
+                        dw.event(File_chg, path, "")
+                    }
+                }
+            case ev.Mask & syscall.IN_DELETE != 0:
+                in.remove(path, isDir)
+            case ev.Mask & syscall.IN_MOVED_FROM != 0:
+                from[ev.Cookie] = moveFrom{path, isDir, time.Now()}
+                in.order = append(in.order, ev.Cookie)
+        }
+    }
+}
+func (in *dirInotify) create(path string, isDir bool) {
+    dw := in.dw
+    if isDir {
+        if dw.skipDir(path) {
+            return
+        }
+        // files may be there already (mkdir -p, moved in)
+        now := in.addTree(path)
+        for _, file := range sortedPaths(now) {
+            if _, ok := dw.files[file]; ok {
+                continue
+            }
+            dw.files[file] = now[file]
+            dw.event(File_new, file, "")
+        }
+        return
+    }
+    // seen already by the scan of new directory
+    if _, ok := dw.files[path]; ok {
+        return
+    }
+    if dw.wanted(path) {
+        if st, ok := dw.stat(path); ok {
+            dw.files[path] = st
+            dw.event(File_new, path, "")
+        }
+    }
+}
+func (in *dirInotify) remove(path string, isDir bool) {
+    dw := in.dw
+    if isDir {
+        in.rmTree(path)
+        var gone []string
+        for file := range dw.files {
+            if under(file, path) {
+                gone = append(gone, file)
+            }
+        }
+        sort.Strings(gone)
+        for _, file := range gone {
+            delete(dw.files, file)
+            dw.event(File_mis, file, "")
+        }
+        return
+    }
+    if _, ok := dw.files[path]; ok {
+        delete(dw.files, path)
+        dw.event(File_mis, path, "")
+    }
+}
+func (in *dirInotify) move(old, path string, isDir bool) {
+    dw := in.dw
+    if !isDir {
+        _, known := dw.files[old]
+        delete(dw.files, old)
+        switch {
+            case known && dw.wanted(path):
+                dw.files[path], _ = dw.stat(path)
+                dw.event(File_mov, path, old)
+            case known:
+                dw.event(File_mis, old, "")
+            case dw.wanted(path):
+                dw.files[path], _ = dw.stat(path)
+                dw.event(File_new, path, "")
+        }
+        return
+    }
+
+    // directory, every file under it moves
+    known := make(map[string]bool)
+    for file := range dw.files {
+        if under(file, old) {
+            known[file] = true
+            delete(dw.files, file)
+        }
+    }
+    in.rmTree(old)
+
+    var now map[string]fileState
+    if ! dw.skipDir(path) {
+        now = in.addTree(path)
+    }
+    for _, file := range sortedPaths(now) {
+        was := old + strings.TrimPrefix(file, path)
+        dw.files[file] = now[file]
+        if known[was] {
+            delete(known, was)
+            dw.event(File_mov, file, was)
+            continue
+        }
+        dw.event(File_new, file, "")
+    }
+    for _, file := range sortedPaths(known) {
+        dw.event(File_mis, file, "")
+    }
+}
+func sortedPaths[V any](m map[string]V) []string {
+    paths := make([]string, 0, len(m))
+    for path := range m {
+        paths = append(paths, path)
+    }
+    sort.Strings(paths)
+    return paths
+}
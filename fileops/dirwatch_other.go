@@ -0,0 +1,11 @@
+//go:build !linux
+
+package fileops
+import (
+    "errors"
+)
+
+type dirInotify struct{}
+func newDirInotify(dw *dirWatch) (*dirInotify, error) { return nil, errors.New("inotify not supported") }
+func (in *dirInotify) loop() {}
+func (in *dirInotify) close() {}
@@ -0,0 +1,98 @@
+package fileops
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "testing"
+    "time"
+)
+
+// events are n DirEvents from dw as "status path <- old" relative to root,
+// sorted, order between files is not kept
+
+func events(t *testing.T, dw FileObj, n int) []string {
+    t.Helper()
+
+    var got []string
+    for len(got) < n {
+        e := recv(dw, recv_zzzZZzz * time.Millisecond)
+        if e == nil {
+            t.Fatal("timeout, got", got)
+        }
+
+        de := e.(DirEvent)
+        path, _ := filepath.Rel(dw.Path(), de.Path())
+        old := ""
+        if de.OldPath() != "" {
+            old, _ = filepath.Rel(dw.Path(), de.OldPath())
+        }
+        got = append(got, statusNames[de.Status()] + " " + path + " <- " + old)
+    }
+    sort.Strings(got)
+
+    return got
+}
+
+func mkdir(t *testing.T, path string) {
+    t.Helper()
+
+    if err := os.MkdirAll(path, 0755); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func rename(t *testing.T, from, to string) {
+    t.Helper()
+
+    if err := os.Rename(from, to); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestDirWatcher(t *testing.T) {
+    for _, poll := range []bool{false, true} {
+        d := t.TempDir()
+        mkdir(t, filepath.Join(d, "a/.git"))
+        writeFile(t, filepath.Join(d, "a/x.log"), "")
+
+        dw := NewDirWatcher(d, Include("*.log"), Exclude(".git"), DirPoll(poll))
+
+        // new files, also in new dirs, excluded and not included ones not
+
+        writeFile(t, filepath.Join(d, "a/y.log"), "")
+        writeFile(t, filepath.Join(d, "a/.git/z.log"), "")
+        writeFile(t, filepath.Join(d, "a/y.txt"), "")
+        mkdir(t, filepath.Join(d, "b/c"))
+        writeFile(t, filepath.Join(d, "b/c/n.log"), "")
+
+        if got, want := events(t, dw, 2), []string{"new a/y.log <- ", "new b/c/n.log <- "}; !reflect.DeepEqual(got, want) {
+            t.Fatalf("poll %v: got %q, want %q", poll, got, want)
+        }
+
+        // renames of files and of dirs with files in them
+
+        writeFile(t, filepath.Join(d, "a/x.log"), "more")
+        rename(t, filepath.Join(d, "a/y.log"), filepath.Join(d, "a/w.log"))
+        rename(t, filepath.Join(d, "b"), filepath.Join(d, "bb"))
+
+        if got, want := events(t, dw, 3), []string{"chg a/x.log <- ", "mov a/w.log <- a/y.log", "mov bb/c/n.log <- b/c/n.log"}; !reflect.DeepEqual(got, want) {
+            t.Fatalf("poll %v: got %q, want %q", poll, got, want)
+        }
+
+        if err := os.RemoveAll(filepath.Join(d, "bb")); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.Remove(filepath.Join(d, "a/x.log")); err != nil {
+            t.Fatal(err)
+        }
+
+        if got, want := events(t, dw, 2), []string{"mis a/x.log <- ", "mis bb/c/n.log <- "}; !reflect.DeepEqual(got, want) {
+            t.Fatalf("poll %v: got %q, want %q", poll, got, want)
+        }
+
+        quiet(t, dw, dir_zzzZZzz * 3 / 2 * time.Millisecond)
+        dw.Stop()
+    }
+}
@@ -1,5 +1,6 @@
 package fileops
 import (
+    "context"
     "syscall"
     "io/fs"
     "errors"
@@ -11,13 +12,20 @@ const (
     File_cut
     File_mis
     File_chg
+    File_mov
+    File_err // terminal (DirWatcher, TailGlob go on), Data() is the error
+    File_ovf // line over MaxLine, cut
+    File_drn // rest of rotated file, before switching over
 )
 
+// FileObj runs until Stop() or its context is done,
+// Comms() is closed after that (or after File_err)
 type FileObj interface {
     Path() string
     Ino() uint64
     Exists() bool
     Comms() chan Notify
+    Stop()
 }
 type Notify interface {
     Path() string
@@ -25,13 +33,59 @@ type Notify interface {
     Data() any
 }
 
-func stat(path string) syscall.Stat_t {
+type fileErr struct {
+    path string
+    err error
+}
+func (fe fileErr) Path() string { return fe.path }
+func (fe fileErr) Status() uint8 { return File_err }
+func (fe fileErr) Data() any { return fe.err }
+
+// runner is what Tail and Watchers share
+// to be stopped
+type runner struct {
+    ctx context.Context
+    cancel context.CancelFunc
+    done chan struct{}
+}
+func newRunner(ctx context.Context) runner {
+    ctx, cancel := context.WithCancel(ctx)
+    return runner{ctx, cancel, make(chan struct{})}
+}
+// Stop returns once files are closed
+func (r runner) Stop() {
+    r.cancel()
+    <-r.done
+}
+// stopped is true once done
+func (r runner) stopped() bool {
+    select {
+        case <-r.done:
+            return true
+        default:
+            return false
+    }
+}
+// send is false when stopped meanwhile
+func (r runner) send(comms chan Notify, n Notify) bool {
+    select {
+        case comms <- n:
+            return true
+        case <-r.ctx.Done():
+            return false
+    }
+}
+
+// stat of path that is not there (also when part
+// of the path is not a directory) is zero, no error
+func stat(path string) (syscall.Stat_t, error) {
     var stat syscall.Stat_t
     err := syscall.Stat(path, &stat)
     if err != nil {
-        if ! errors.Is(err, fs.ErrNotExist) {
-            panic(err)
+        if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+            return syscall.Stat_t{}, nil
         }
+        return stat, &fs.PathError{Op: "stat", Path: path, Err: err}
     }
-    return stat
+    return stat, nil
 }
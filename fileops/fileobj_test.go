@@ -0,0 +1,111 @@
+package fileops
+
+import (
+    "fmt"
+    "os"
+    "reflect"
+    "testing"
+    "time"
+)
+
+const (
+    // milliseconds, longest wait for a notify
+    recv_zzzZZzz = 3000
+)
+
+var statusNames = [...]string{
+    File_std: "std",
+    File_new: "new",
+    File_cut: "cut",
+    File_mis: "mis",
+    File_chg: "chg",
+    File_mov: "mov",
+    File_err: "err",
+    File_ovf: "ovf",
+    File_drn: "drn",
+}
+
+// note is a notify as tests compare it
+type note struct {
+    status uint8
+    data string
+}
+func (n note) String() string {
+    return fmt.Sprintf("%s:%q", statusNames[n.status], n.data)
+}
+
+// recv is the next notify from fo, nil after d
+func recv(fo FileObj, d time.Duration) Notify {
+    select {
+        case n := <-fo.Comms():
+            return n
+        case <-time.After(d):
+            return nil
+    }
+}
+
+// until collects tail chunks from fo up to and including data last
+
+func until(t *testing.T, fo FileObj, last string) []note {
+    t.Helper()
+
+    var got []note
+    for {
+        n := recv(fo, recv_zzzZZzz * time.Millisecond)
+        if n == nil {
+            t.Fatal("timeout, got", got)
+        }
+
+        d, _ := n.Data().(string)
+        got = append(got, note{n.Status(), d})
+        if d == last {
+            return got
+        }
+    }
+}
+
+func expect(t *testing.T, got []note, want ...note) {
+    t.Helper()
+
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("got %v, want %v", got, want)
+    }
+}
+
+// quiet fails on anything from fo within d
+func quiet(t *testing.T, fo FileObj, d time.Duration) {
+    t.Helper()
+
+    if n := recv(fo, d); n != nil {
+        t.Fatalf("unexpected %s:%v", statusNames[n.Status()], n.Data())
+    }
+}
+
+func writeFile(t *testing.T, path, s string) {
+    t.Helper()
+
+    if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// appender is path opened for writing at its end, closed with the test
+func appender(t *testing.T, path string) *os.File {
+    t.Helper()
+
+    f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { f.Close() })
+
+    return f
+}
+
+func write(t *testing.T, f *os.File, s string) {
+    t.Helper()
+
+    if _, err := f.WriteString(s); err != nil {
+        t.Fatal(err)
+    }
+}
@@ -0,0 +1,96 @@
+package fileops
+import (
+    "fmt"
+    "io"
+    "os"
+)
+const (
+    // where Tail starts
+    from_end = iota
+    from_start
+    from_offset
+    from_lines
+)
+
+// Start position, end of file unless
+//  FromStart       - beginning (cat)
+//  FromOffset(n)   - n bytes in (tail -c +N, but counted from 0)
+//  FromLines(n)    - last n lines (tail -n N)
+// Saved Checkpoint wins over any of them.
+type startFrom struct {
+    whence uint8
+    n int64
+}
+
+// Config
+func FromStart() func(*fileTail) {
+    return func(ft *fileTail) {
+        ft.from = startFrom{from_start, 0}
+    }
+}
+// FromOffset past the end starts at the end
+func FromOffset(offset int64) func(*fileTail) {
+    if offset < 0 {
+        panic(fmt.Sprintf("Offset negative: %d", offset))
+    }
+    return func(ft *fileTail) {
+        ft.from = startFrom{from_offset, offset}
+    }
+}
+func FromLines(lines int) func(*fileTail) {
+    if lines < 0 {
+        panic(fmt.Sprintf("Lines negative: %d", lines))
+    }
+    return func(ft *fileTail) {
+        ft.from = startFrom{from_lines, int64(lines)}
+    }
+}
+
+// seekFrom seeks to where ft.from says
+func (ft *fileTail) seekFrom() error {
+    switch ft.from.whence {
+        case from_start:
+            return ft.seekFileStart()
+        case from_offset:
+            if ft.from.n > ft.size {
+                return ft.seekFileEnd()
+            }
+            return ft.seekFileSet(ft.from.n)
+        case from_lines:
+            offset, err := lastLines(ft.fh, ft.size, ft.from.n, ft.buff)
+            if err != nil {
+                return err
+            }
+            return ft.seekFileSet(offset)
+    }
+    return ft.seekFileEnd()
+}
+
+// lastLines is offset of the last n lines of fh (size long),
+// reads it backwards block by block. Newline at the very
+// end does not start another line.
+func lastLines(fh *os.File, size, n int64, block int) (int64, error) {
+    if n == 0 {
+        return size, nil
+    }
+    b := make([]byte, block)
+    for pos := size; pos > 0; {
+        l := int64(block)
+        if pos < l {
+            l = pos
+        }
+        pos -= l
+        if _, err := fh.ReadAt(b[:l], pos); err != nil && err != io.EOF {
+            return 0, err
+        }
+        for i := l-1; i >= 0; i-- {
+            if b[i] != '\n' || pos+i == size-1 {
+                continue
+            }
+            if n--; n == 0 {
+                return pos+i+1, nil
+            }
+        }
+    }
+    return 0, nil
+}
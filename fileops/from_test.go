@@ -0,0 +1,75 @@
+package fileops
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestLastLines(t *testing.T) {
+    p := filepath.Join(t.TempDir(), "log")
+
+    for _, c := range []struct {
+        s       string
+        n       int64
+        offset  int64
+    }{
+        {"1\n22\n333\n", 0, 9},
+        {"1\n22\n333\n", 1, 5},
+        {"1\n22\n333\n", 2, 2},
+        {"1\n22\n333\n", 3, 0},
+        {"1\n22\n333\n", 9, 0},
+        {"1\n22\ntail", 1, 5}, // no newline at the end
+        {"1\n22\ntail", 2, 2},
+        {"\n\n", 1, 1},
+        {"", 1, 0},
+    } {
+        writeFile(t, p, c.s)
+
+        fh, err := os.Open(p)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        // blocks smaller than lines, and bigger than the file
+        for _, block := range []int{1, 3, 64} {
+            if offset, err := lastLines(fh, int64(len(c.s)), c.n, block); err != nil || offset != c.offset {
+                t.Fatalf("%q last %d, block %d: %d, want %d (%v)", c.s, c.n, block, offset, c.offset, err)
+            }
+        }
+        fh.Close()
+    }
+}
+
+func TestFrom(t *testing.T) {
+    p := filepath.Join(t.TempDir(), "log")
+    writeFile(t, p, "1\n2\n3\n")
+
+    for _, c := range []struct {
+        from    TailConf
+        want    string
+    }{
+        {FromStart(), "1\n2\n3\n"},
+        {FromOffset(2), "2\n3\n"},
+        {FromLines(2), "2\n3\n"},
+        {FromLines(0), "x\n"},
+        {FromOffset(30), "x\n"}, // past the end
+        {func(*fileTail) {}, "x\n"}, // end
+    } {
+        ft := NewTail(p, c.from)
+        time.Sleep(50 * time.Millisecond)
+
+        if c.want == "x\n" {
+            write(t, appender(t, p), "x\n")
+        }
+
+        n := recv(ft, recv_zzzZZzz * time.Millisecond)
+        if n == nil || n.Data() != c.want {
+            t.Fatalf("got %v, want %q", n, c.want)
+        }
+        ft.Stop()
+
+        writeFile(t, p, "1\n2\n3\n")
+    }
+}
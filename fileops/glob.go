@@ -0,0 +1,165 @@
+package fileops
+import (
+    "context"
+    "fmt"
+    "path/filepath"
+    "sync"
+    "time"
+)
+const (
+    // milliseconds
+    glob_zzzZZzz = 1000
+    glob_gone = 5000
+)
+
+// TailGlob tails every file matching pattern (filepath.Glob),
+// all chunks come through one Comms(), Path() of each is the file.
+// Pattern is looked at again every glob_zzzZZzz, files showing up
+// later are tailed from the beginning (first chunk File_new), files
+// no longer there for glob_gone are dropped. File rotated to a name
+// that matches too (eg. app.log.1 for app.log*) is taken up where
+// it is (no matter the start position or Checkpoint), the old
+// tail drains it first. File_err of a file is passed on, the file
+// is tried again after glob_gone.
+// Conf applies to every file.
+func TailGlob(pattern string, conf ...TailConf) FileObj {
+    return TailGlobContext(context.Background(), pattern, conf...)
+}
+// TailGlobContext stops tailing when ctx is done
+func TailGlobContext(ctx context.Context, pattern string, conf ...TailConf) FileObj {
+    if _, err := filepath.Match(pattern, ""); err != nil {
+        panic(fmt.Sprintf("Invalid pattern: %s", pattern))
+    }
+    gt := &globTail{
+        pattern: pattern,
+        conf: conf,
+        tails: make(map[string]*globFile),
+        comms: make(chan Notify),
+        runner: newRunner(ctx),
+    }
+
+    // files there now before returning,
+    // same as NewTail() would
+    gt.inodes = gt.rescan(nil)
+
+    go func(gt *globTail) {
+        defer close(gt.done)
+        defer close(gt.comms)
+        defer gt.cancel()
+        defer gt.wg.Wait()
+
+        wake := poller{gt.ctx, time.Duration(glob_zzzZZzz) * time.Millisecond}
+        for wake.wait(0) {
+            gt.inodes = gt.rescan(gt.inodes)
+        }
+    }(gt)
+
+    return gt
+}
+
+type globFile struct {
+    ft *fileTail
+    gone time.Time // not matching (or ended) since
+}
+
+type globTail struct {
+    pattern string
+    conf []TailConf
+    mu sync.Mutex
+    tails map[string]*globFile
+    inodes map[uint64]string // as of last rescan
+    comms chan Notify
+    wg sync.WaitGroup
+    runner
+}
+func (gt *globTail) Path() string { return gt.pattern }
+func (gt *globTail) Ino() uint64 { return 0 }
+func (gt *globTail) Comms() chan Notify { return gt.comms }
+// Exists is true while there is a file to tail
+func (gt *globTail) Exists() bool {
+    gt.mu.Lock()
+    defer gt.mu.Unlock()
+    return len(gt.tails) > 0
+}
+
+// rescan starts tails of new matches and drops the ones gone
+// for good, inodes is what matched last time (nil the first time),
+// returns what matches now
+func (gt *globTail) rescan(inodes map[uint64]string) map[uint64]string {
+    matches, _ := filepath.Glob(gt.pattern)
+    cur := make(map[uint64]string, len(matches))
+    seen := make(map[string]uint64, len(matches))
+    for _, path := range matches {
+        // stat errors are for the tail to tell
+        st, err := stat(path)
+        if err == nil && st.Ino == 0 {
+            continue
+        }
+        seen[path] = st.Ino
+        if st.Ino != 0 {
+            cur[st.Ino] = path
+        }
+    }
+
+    gt.mu.Lock()
+    defer gt.mu.Unlock()
+    for path, gf := range gt.tails {
+        _, ok := seen[path]
+        switch {
+            // ended on error, tried again
+            // in a while if still there
+            case gf.ft.stopped() && gf.gone.IsZero():
+                gf.gone = time.Now()
+            case ok && ! gf.ft.stopped():
+                gf.gone = time.Time{}
+            case gf.gone.IsZero():
+                gf.gone = time.Now()
+            case time.Since(gf.gone) >= time.Duration(glob_gone) * time.Millisecond:
+                gf.ft.Stop()
+                delete(gt.tails, path)
+        }
+    }
+    for path, ino := range seen {
+        if _, ok := gt.tails[path]; ok {
+            continue
+        }
+        conf := gt.conf
+        if inodes != nil && ino != 0 {
+            from := fromNew
+            if _, moved := inodes[ino]; moved {
+                from = fromMoved
+            }
+            conf = append(conf[:len(conf):len(conf)], from)
+        }
+        ft := newTail(gt.ctx, path, conf...)
+        if ft == nil {
+            // gone already
+            continue
+        }
+        gt.tails[path] = &globFile{ft: ft}
+        gt.wg.Add(1)
+        go gt.forward(ft)
+    }
+    return cur
+}
+// forward passes everything ft sends on,
+// ends when ft is done
+func (gt *globTail) forward(ft *fileTail) {
+    defer gt.wg.Done()
+    for n := range ft.comms {
+        if ! gt.send(gt.comms, n) {
+            ft.cancel()
+        }
+    }
+}
+// fromNew is for files showing up later
+func fromNew(ft *fileTail) {
+    ft.from = startFrom{from_start, 0}
+    ft.status = File_new
+}
+// fromMoved is for files tailed under another name until now,
+// whatever conf says, the old tail got it up to here
+func fromMoved(ft *fileTail) {
+    ft.from = startFrom{from_end, 0}
+    ft.cp = nil
+}
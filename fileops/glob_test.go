@@ -0,0 +1,86 @@
+package fileops
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// globbed collects "status file data" from gt up to and including data last
+
+func globbed(t *testing.T, gt FileObj, last string) []string {
+    t.Helper()
+
+    var got []string
+    for {
+        n := recv(gt, recv_zzzZZzz * time.Millisecond)
+        if n == nil {
+            t.Fatal("timeout, got", got)
+        }
+
+        d, _ := n.Data().(string)
+        got = append(got, statusNames[n.Status()] + " " + filepath.Base(n.Path()) + " " + d)
+        if d == last {
+            return got
+        }
+    }
+}
+
+func TestTailGlob(t *testing.T) {
+    dir := t.TempDir()
+    a := filepath.Join(dir, "a.log")
+    b := filepath.Join(dir, "b.log")
+    writeFile(t, a, "old\n")
+
+    gt := TailGlob(filepath.Join(dir, "*.log*"), Lines(true))
+    defer gt.Stop()
+    time.Sleep(50 * time.Millisecond)
+
+    // files there at the start are tailed from the end,
+    // later ones from the beginning
+
+    f := appender(t, a)
+    write(t, f, "a1\n")
+    writeFile(t, b, "b1\nb2\n")
+
+    if got, want := globbed(t, gt, "b2"), []string{"std a.log a1", "new b.log b1", "std b.log b2"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("got %q, want %q", got, want)
+    }
+
+    // rotated to a matching name, taken up where it is
+
+    rename(t, a, a+".1")
+    write(t, f, "late\n")
+    writeFile(t, a, "new\n")
+
+    if got, want := globbed(t, gt, "new"), []string{"drn a.log late", "new a.log new"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("got %q, want %q", got, want)
+    }
+
+    time.Sleep(glob_zzzZZzz * 3 / 2 * time.Millisecond)
+    write(t, f, "later\n")
+
+    if got, want := globbed(t, gt, "later"), []string{"std a.log.1 later"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("got %q, want %q", got, want)
+    }
+
+    // gone for glob_gone, dropped
+
+    if err := os.Remove(b); err != nil {
+        t.Fatal(err)
+    }
+
+    tails := func() int {
+        g := gt.(*globTail)
+        g.mu.Lock()
+        defer g.mu.Unlock()
+        return len(g.tails)
+    }
+    for start := time.Now(); tails() != 2; time.Sleep(100 * time.Millisecond) {
+        if time.Since(start) > (glob_gone + 3 * glob_zzzZZzz) * time.Millisecond {
+            t.Fatalf("%d files tailed", tails())
+        }
+    }
+}
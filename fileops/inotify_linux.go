@@ -0,0 +1,93 @@
+//go:build linux
+
+package fileops
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "time"
+    "unsafe"
+)
+const (
+    // milliseconds, look even if no event came
+    // (missed events, network filesystems)
+    inotify_zzzZZzz = 10000
+
+    in_file = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_MOVE_SELF | syscall.IN_DELETE_SELF
+    in_dir = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+)
+
+// inotify watches the file itself and its parent dir
+// for the file (re)appearing under path
+type inotify struct {
+    path, base string
+    fd int
+    fh *os.File // non-blocking, read deadlines
+    dir, file int32 // watch descriptors
+    buf []byte
+}
+func newInotify(path string) (*inotify, error) {
+    fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+    if err != nil {
+        return nil, err
+    }
+    in := &inotify{path: path, base: filepath.Base(path), fd: fd, file: -1, buf: make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))}
+    in.fh = os.NewFile(uintptr(fd), "inotify")
+
+    wd, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), in_dir)
+    if err != nil {
+        in.fh.Close()
+        return nil, err
+    }
+    in.dir = int32(wd)
+    in.watchFile()
+    return in, nil
+}
+func (in *inotify) close() { in.fh.Close() }
+
+// watchFile (re)adds watch on path, same inode keeps its wd,
+// replaced file gets a new one, missing file none
+func (in *inotify) watchFile() {
+    wd, err := syscall.InotifyAddWatch(in.fd, in.path, in_file)
+    if err != nil {
+        wd = -1
+    }
+    if in.file >= 0 && int32(wd) != in.file {
+        syscall.InotifyRmWatch(in.fd, uint32(in.file))
+    }
+    in.file = int32(wd)
+}
+func (in *inotify) wait(max time.Duration) bool {
+    d := time.Duration(inotify_zzzZZzz) * time.Millisecond
+    if max > 0 && max < d {
+        d = max
+    }
+    in.fh.SetReadDeadline(time.Now().Add(d))
+    for {
+        n, err := in.fh.Read(in.buf)
+        if err != nil {
+            // timeout or closed
+            return false
+        }
+        if in.relevant(in.buf[:n]) {
+            in.watchFile()
+            return true
+        }
+    }
+}
+
+// relevant is any event on the file,
+// or on the parent dir for our file name
+func (in *inotify) relevant(b []byte) bool {
+    for off := 0; off+syscall.SizeofInotifyEvent <= len(b); {
+        ev := (*syscall.InotifyEvent)(unsafe.Pointer(&b[off]))
+        name := b[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+        off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+        if ev.Wd != in.dir || strings.TrimRight(string(name), "\x00") == in.base {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fileops
+import (
+    "errors"
+    "time"
+)
+
+type inotify struct{}
+func newInotify(path string) (*inotify, error) { return nil, errors.New("inotify not supported") }
+func (in *inotify) wait(max time.Duration) bool { return false }
+func (in *inotify) close() {}
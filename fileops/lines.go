@@ -0,0 +1,120 @@
+package fileops
+import (
+    "bytes"
+    "fmt"
+    "time"
+)
+const (
+    // milliseconds
+    line_flush = 2000
+
+    line_max = 64*1024
+)
+
+// Line mode, Tchunk is a single line without the newline.
+// Partial line waits for the rest until LineFlush is up,
+// then goes out as it is. Line longer than MaxLine is sent
+// in MaxLine pieces with File_ovf, but the last one. Status
+// for the next line (File_new, File_cut, File_drn) waits
+// for the first piece that is not File_ovf.
+type lineBuf struct {
+    on bool
+    flush time.Duration
+    max int
+    partial []byte
+    since time.Time // partial waiting since
+}
+
+// Config
+func Lines(b bool) func(*fileTail) {
+    return func(ft *fileTail) {
+        ft.line.on = b
+    }
+}
+// LineFlush 0 waits for the newline forever
+func LineFlush(d time.Duration) func(*fileTail) {
+    if d < 0 {
+        panic(fmt.Sprintf("Line flush negative: %s", d))
+    }
+    return func(ft *fileTail) {
+        ft.line.flush = d
+    }
+}
+func MaxLine(max int) func(*fileTail) {
+    if max < 1 || max > buff_max {
+        panic(fmt.Sprintf("Line length overflow: 1 .. %d", buff_max))
+    }
+    return func(ft *fileTail) {
+        ft.line.max = max
+    }
+}
+
+// lines sends complete lines of b (plus what was left before),
+// ft.status goes with the first one. False when stopped meanwhile.
+func (ft *fileTail) lines(b []byte) bool {
+    lb := &ft.line
+    lb.partial = append(lb.partial, b...)
+    for {
+        var line []byte
+        var s = ft.status
+        i := bytes.IndexByte(lb.partial, '\n')
+        switch {
+            case i >= 0 && i <= lb.max:
+                line, lb.partial = lb.partial[:i], lb.partial[i+1:]
+            case len(lb.partial) > lb.max:
+                line, lb.partial = lb.partial[:lb.max], lb.partial[lb.max:]
+                s = File_ovf
+            default:
+                // keep the rest, don't let it grow
+                // over a single line
+                lb.partial = append([]byte(nil), lb.partial...)
+                if len(lb.partial) == 0 {
+                    lb.since = time.Time{}
+                } else if lb.since.IsZero() {
+                    lb.since = time.Now()
+                }
+                return true
+        }
+        if ! ft.send(ft.comms, Tchunk{ft.path, s, string(line)}) {
+            return false
+        }
+        if s != File_ovf {
+            ft.status = File_std
+        }
+        lb.since = time.Time{}
+    }
+}
+
+// flushLine sends partial line if it waited long enough,
+// or now if force
+func (ft *fileTail) flushLine(force bool) bool {
+    lb := &ft.line
+    if len(lb.partial) == 0 {
+        return true
+    }
+    if ! force && (lb.flush == 0 || time.Since(lb.since) < lb.flush) {
+        return true
+    }
+    line := string(lb.partial)
+    lb.partial = nil
+    lb.since = time.Time{}
+    if ! ft.send(ft.comms, Tchunk{ft.path, ft.status, line}) {
+        return false
+    }
+    ft.status = File_std
+    return true
+}
+
+// flushIn is how long waker may sleep for partial
+// line to be flushed on time, 0 no limit
+func (ft *fileTail) flushIn() time.Duration {
+    lb := &ft.line
+    if len(lb.partial) == 0 || lb.flush == 0 {
+        return 0
+    }
+    d := lb.flush - time.Since(lb.since)
+    if d <= 0 {
+        d = time.Millisecond
+    }
+    return d
+}
@@ -0,0 +1,44 @@
+package fileops
+
+import (
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestLines(t *testing.T) {
+    for _, poll := range []bool{false, true} {
+        p := filepath.Join(t.TempDir(), "log")
+        writeFile(t, p, "")
+
+        flush := 1200 * time.Millisecond
+        ft := NewTail(p, Lines(true), MaxLine(5), LineFlush(flush), Poll(poll))
+        time.Sleep(50 * time.Millisecond)
+
+        // line split between writes is put together,
+        // long one cut in MaxLine pieces
+
+        f := appender(t, p)
+        write(t, f, "one\ntw")
+        time.Sleep(100 * time.Millisecond)
+        write(t, f, "o\nlonglongline\npart")
+        start := time.Now()
+
+        expect(t, until(t, ft, "ne"),
+            note{File_std, "one"},
+            note{File_std, "two"},
+            note{File_ovf, "longl"},
+            note{File_ovf, "ongli"},
+            note{File_std, "ne"},
+        )
+
+        // partial line waits for LineFlush
+
+        expect(t, until(t, ft, "part"), note{File_std, "part"})
+        if d := time.Since(start); d < flush {
+            t.Fatalf("poll %v: partial line flushed after %s", poll, d)
+        }
+
+        ft.Stop()
+    }
+}
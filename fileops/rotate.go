@@ -0,0 +1,67 @@
+package fileops
+import (
+    "errors"
+    "io"
+    "io/fs"
+    "os"
+    "syscall"
+)
+
+// Rotation, old file is read to the end before Tail
+// switches over, that part starts with File_drn.
+//  rename (logrotate default) - old descriptor is still good
+//  copytruncate - rest is in the copy, found by fingerprint
+
+// findCopy looks next to path for a copy of cp's file
+// made before it was truncated, "" if there is none
+func findCopy(path string, cp checkpoint) string {
+    if cp.FpLen == 0 {
+        return ""
+    }
+    return findSibling(path, cp, func(st syscall.Stat_t) bool {
+        return st.Ino != cp.Ino && st.Size >= cp.Offset
+    })
+}
+// drain reads (rotated) file at path from offset to the end
+func (ft *fileTail) drain(path string, ino uint64, offset int64) error {
+    fh, err := os.Open(path)
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return nil
+        }
+        return err
+    }
+    defer fh.Close()
+    if _, err := fh.Seek(offset, io.SeekStart); err != nil {
+        return err
+    }
+    return ft.drainFile(fh, ino, offset)
+}
+// drainFile reads fh (inode ino) from offset to the end,
+// stopped Tail leaves it half way
+func (ft *fileTail) drainFile(fh *os.File, ino uint64, offset int64) error {
+    var bytes = make([]byte, ft.buff)
+    for ft.ctx.Err() == nil {
+        n, err := fh.Read(bytes)
+        if n > 0 {
+            offset += int64(n)
+            if ! ft.emit(bytes[:n]) {
+                return nil
+            }
+            if err := ft.mark(fh, ino, offset); err != nil {
+                return err
+            }
+        }
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+    }
+    // nothing more coming to the partial line
+    if ! ft.flushLine(true) {
+        return nil
+    }
+    return ft.mark(fh, ino, offset)
+}
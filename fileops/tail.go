@@ -1,8 +1,12 @@
 package fileops
 import (
+    "bytes"
+    "context"
+    "errors"
     "fmt"
-    "os"
     "io"
+    "io/fs"
+    "os"
     "time"
 )
 const (
@@ -30,6 +34,13 @@ func Reopen(b bool) func(*fileTail) {
         ft.reopen = b
     }
 }
+// Poll stats the file every loop_zzzZZzz instead of inotify
+// (eg. network filesystems)
+func Poll(b bool) func(*fileTail) {
+    return func(ft *fileTail) {
+        ft.poll = b
+    }
+}
 
 // Chunk
 type Tchunk struct {
@@ -47,19 +58,26 @@ func (tc Tchunk) Data() any {
     return tc.s
 }
 
-// TODO do a first byte to be able to check file overwrite within size??
-// C tail somehow knows when echo "bla" > file.txt is done even when the text is same.. :?
-
 // Tail
 type fileTail struct {
     path string
     fh *os.File
     ino uint64
     size, pos int64
+    ctime int64 // nanoseconds
     buff int
     reopen bool // -f vs -F
     status uint8
     comms chan Notify
+    poll bool
+    wake waker
+    line lineBuf
+    cp *checkpoints
+    from startFrom
+    fp string // fingerprint of fpLen first bytes
+    fpLen int
+    last []byte // up to fp_len bytes read last
+    runner
 }
 func (ft *fileTail) Path() string { return ft.path }
 func (ft *fileTail) Ino() uint64 { return ft.ino }
@@ -76,120 +94,341 @@ func (ft *fileTail) close() {
     ft.size = 0
     ft.pos = 0
 }
-func (ft *fileTail) updateInode() {
-    stat := stat(ft.path)
+func (ft *fileTail) updateInode() error {
+    stat, err := stat(ft.path)
+    if err != nil {
+        return err
+    }
     ft.ino = stat.Ino
     ft.size = stat.Size
+    ft.ctime = stat.Ctim.Nano()
     //fmt.Println(ft.fh.Fd())
+    return nil
 }
-func (ft *fileTail) openFile() {
+func (ft *fileTail) openFile() error {
     // TODO what info does *File provide,
     //      can we update the inode from it?
     fh, err := os.Open(ft.path)
     if err != nil {
-        panic(err)
+        return err
     }
     ft.fh = fh
-    ft.updateInode()
+    ft.fp, ft.fpLen, ft.last = "", 0, nil
+    return ft.updateInode()
 }
-func (ft *fileTail) seekFileStart()      { ft.seekFile(0, os.SEEK_SET) }
-func (ft *fileTail) seekFileEnd()        { ft.seekFile(0, os.SEEK_END) }
-func (ft *fileTail) seekFileSet(i int64) { ft.seekFile(i, os.SEEK_SET) }
-func (ft *fileTail) seekFile(offset int64, whence int) {
+func (ft *fileTail) seekFileStart() error      { return ft.seekFile(0, io.SeekStart) }
+func (ft *fileTail) seekFileEnd() error        { return ft.seekFile(0, io.SeekEnd) }
+func (ft *fileTail) seekFileSet(i int64) error { return ft.seekFile(i, io.SeekStart) }
+func (ft *fileTail) seekFile(offset int64, whence int) error {
     pos, err := ft.fh.Seek(offset, whence)
     if err != nil {
-        panic(err)
+        return err
     }
     ft.pos = pos
+    return nil
 }
-func (ft *fileTail) readFile(bytes []byte) int {
+func (ft *fileTail) readFile(bytes []byte) (int, error) {
     p, err := ft.fh.Read(bytes)
     if err != nil && err != io.EOF {
-        panic(err)
+        return 0, err
     }
     ft.pos += int64(p)
-    ft.seekFileSet(ft.pos)
-    return p
+    // remember the start of file until there is enough
+    // of it, copytruncate leaves nothing to compare
+    if p > 0 && ft.fpLen < fp_len {
+        if ft.fp, ft.fpLen, err = fingerprint(ft.fh, fp_len); err != nil {
+            return 0, err
+        }
+    }
+    // and the end of what was read, to tell
+    // same size rewrite from touch
+    if p > 0 {
+        b := bytes[:p]
+        if len(b) > fp_len {
+            b = b[len(b)-fp_len:]
+        }
+        keep := fp_len - len(b)
+        if keep > len(ft.last) {
+            keep = len(ft.last)
+        }
+        ft.last = append(append([]byte(nil), ft.last[len(ft.last)-keep:]...), b...)
+    }
+    return p, ft.seekFileSet(ft.pos)
 }
 
 func NewTail(path string, conf ...TailConf) FileObj {
-    ft := &fileTail{path, nil, 0, 0, 0, buff_std, false, File_std, make(chan Notify)}
-    ft.updateInode()
+    return NewTailContext(context.Background(), path, conf...)
+}
+// NewTailContext stops tailing when ctx is done
+func NewTailContext(ctx context.Context, path string, conf ...TailConf) FileObj {
+    ft := newTail(ctx, path, conf...)
+    if ft == nil {
+        panic("No such file or directory: " + path)
+    }
+    return ft
+}
+// newTail is nil if path does not exist
+func newTail(ctx context.Context, path string, conf ...TailConf) *fileTail {
+    ft := &fileTail{
+        path: path,
+        buff: buff_std,
+        status: File_std,
+        comms: make(chan Notify),
+        line: lineBuf{flush: time.Duration(line_flush) * time.Millisecond, max: line_max},
+        runner: newRunner(ctx),
+    }
+    // other errors are for run() to tell
+    err := ft.updateInode()
     //stat := stat(path)
     //ft := &fileTail{path, nil, stat.Ino, 0, 0, buff_std, false, File_std, make(chan Notify)}
-    if ! ft.Exists() {
-        panic("No such file or directory: " + ft.path)
+    if err == nil && ! ft.Exists() {
+        return nil
     }
     for _, tconf := range conf {
         tconf(ft)
     }
 
     go func(ft *fileTail) {
-        ft.openFile()
-        defer ft.close()
-        ft.seekFileEnd()
-        ft.status = File_std
-
-        var ino uint64
-        var size int64
-        var bytes = make([]byte, ft.buff)
-        for {
-            ino = ft.ino
-            size = ft.size
-            ft.updateInode()
-            ft.status = File_std
-
-            // missing file continues to be missing :)
-            if ino == ft.ino && ino == 0 {
-                time.Sleep(time.Duration(loop_zzzZZzz) * time.Millisecond)
-                continue
-            }
+        defer close(ft.done)
+        defer close(ft.comms)
+        defer ft.cancel()
+        ft.wake = newWaker(ft.ctx, ft.path, ft.poll, loop_zzzZZzz)
+        defer ft.wake.close()
+
+        err := ft.run()
+        if e := ft.cp.flush(); err == nil {
+            err = e
+        }
+        if err != nil {
+            ft.send(ft.comms, fileErr{ft.path, err})
+        }
+    }(ft)
+    
+    return ft
+}
+// start seeks to where ft.from says,
+// or where checkpoint says
+func (ft *fileTail) start() error {
+    var cp checkpoint
+    var ok bool
+    if ft.cp != nil {
+        var err error
+        if cp, ok, err = ft.cp.get(ft.path); err != nil {
+            return err
+        }
+    }
+    if ! ok {
+        return ft.seekFrom()
+    }
+    if cp.Ino == ft.ino && cp.matches(ft.fh) {
+        if cp.Offset > ft.size {
+            ft.status = File_cut
+            return ft.seekFileStart()
+        }
+        return ft.seekFileSet(cp.Offset)
+    }
+
+    // rotated (or replaced) while we were away
+    if rotated := findRotated(ft.path, cp); rotated != "" {
+        ft.status = File_drn
+        if err := ft.drain(rotated, cp.Ino, cp.Offset); err != nil {
+            return err
+        }
+    }
+    ft.status = File_new
+    return ft.seekFileStart()
+}
+// emit sends b as chunk, or lines in line mode,
+// false when stopped meanwhile
+func (ft *fileTail) emit(b []byte) bool {
+    if ft.line.on {
+        return ft.lines(b)
+    }
+    if ! ft.send(ft.comms, Tchunk{ft.path, ft.status, string(b)}) {
+        return false
+    }
+    ft.status = File_std
+    return true
+}
+// mark saves offset read of fh (inode ino) to checkpoint,
+// partial line not sent yet does not count
+func (ft *fileTail) mark(fh *os.File, ino uint64, offset int64) error {
+    if ft.cp == nil {
+        return nil
+    }
+    fp, n, err := fingerprint(fh, fp_len)
+    if err != nil {
+        return err
+    }
+    return ft.cp.save(ft.path, checkpoint{ino, fp, n, offset - int64(len(ft.line.partial))})
+}
+// rewritten tells if file changed other than by append since
+// last look (size, ctime), same file as the fingerprint goes,
+// read from the start
+//  File_cut - shorter, or same size but last bytes read are not
+//             there any more (echo bla > file, other text)
+//  File_new - starts differently (truncated and grown past
+//             what was read, or written over)
+// Rewrite with the same text (or touch) is no change.
+func (ft *fileTail) rewritten(size, ctime int64) (uint8, error) {
+    if size > ft.size {
+        return File_cut, nil
+    }
+    // not touched since
+    if ctime == ft.ctime {
+        return File_std, nil
+    }
+    if ft.fpLen > 0 {
+        fp, _, err := fingerprint(ft.fh, ft.fpLen)
+        if err != nil {
+            return File_std, err
+        }
+        if fp != ft.fp {
+            return File_new, nil
+        }
+    }
+    if size == ft.size && len(ft.last) > 0 {
+        b := make([]byte, len(ft.last))
+        n, err := ft.fh.ReadAt(b, ft.pos - int64(len(b)))
+        if err != nil && err != io.EOF {
+            return File_std, err
+        }
+        if ! bytes.Equal(b[:n], ft.last) {
+            return File_cut, nil
+        }
+    }
+    return File_std, nil
+}
+func (ft *fileTail) run() error {
+    if err := ft.openFile(); err != nil {
+        return err
+    }
+    defer ft.close()
+    if err := ft.start(); err != nil {
+        return err
+    }
+
+    var ino uint64
+    var size, ctime int64
+    var bytes = make([]byte, ft.buff)
+    for ft.ctx.Err() == nil {
+        ino = ft.ino
+        size = ft.size
+        ctime = ft.ctime
+        if err := ft.updateInode(); err != nil {
+            return err
+        }
+
+        // missing file continues to be missing :)
+        if ino == ft.ino && ino == 0 {
+            ft.wake.wait(0)
+            continue
+        }
 
-            // check if ino has changed
-            // check size only if ino not changed
+        // check if ino has changed
+        // check size only if ino not changed
 
-            if ino == ft.ino {
-                // truncated
-                if size > ft.size {
-                    ft.status = File_cut
-                    ft.seekFileStart()
+        if ino == ft.ino {
+            // truncated or written over
+            rewritten, err := ft.rewritten(size, ctime)
+            if err != nil {
+                return err
+            }
+            if rewritten != File_std {
+                // copytruncate, what we did not get
+                // to is in the copy
+                if copied := findCopy(ft.path, checkpoint{ft.ino, ft.fp, ft.fpLen, ft.pos}); copied != "" {
+                    stat, err := stat(copied)
+                    if err != nil {
+                        return err
+                    }
+                    ft.status = File_drn
+                    if err := ft.drain(copied, stat.Ino, ft.pos); err != nil || ft.ctx.Err() != nil {
+                        return err
+                    }
                 }
-            } else {
-                // not found
-                if ft.ino == 0 {
-                    fmt.Println("disappeared")
-                    ft.status = File_mis
-                    ft.close()
-                    // TODO - send smth to nofify of file going missing?
-                    ft.comms <- Tchunk{ft.path, ft.status, ""}
-                    continue
+                if ! ft.flushLine(true) {
+                    return nil
+                }
+                ft.status = rewritten
+                if err := ft.seekFileStart(); err != nil {
+                    return err
+                }
+                ft.fp, ft.fpLen, ft.last = "", 0, nil
+            }
+        } else {
+            // old, renamed or deleted while open,
+            // whatever was written to it since last read
+            if ino != 0 {
+                ft.status = File_drn
+                if err := ft.drainFile(ft.fh, ino, ft.pos); err != nil || ft.ctx.Err() != nil {
+                    return err
                 }
+            }
 
-                // ino has changed
-                // old
+            // not found
+            if ft.ino == 0 {
+                ft.status = File_mis
                 ft.close()
-                // new
-                ft.openFile()
-                defer ft.close()
-                ft.seekFileStart()
-                ft.status = File_new
+                if ! ft.send(ft.comms, Tchunk{ft.path, ft.status, ""}) {
+                    return nil
+                }
+                ft.status = File_std
+                continue
             }
 
-            n := ft.readFile(bytes)
-            if n == 0 {
-                time.Sleep(time.Duration(loop_zzzZZzz) * time.Millisecond)
-                continue
+            // new
+            ft.close()
+            if err := ft.openFile(); err != nil {
+                // gone again, next round tells
+                if errors.Is(err, fs.ErrNotExist) {
+                    continue
+                }
+                return err
             }
+            if err := ft.seekFileStart(); err != nil {
+                return err
+            }
+            ft.status = File_new
+        }
 
-            ft.comms <- Tchunk{ft.path, ft.status, string(bytes[:n])} 
-            // zero out the bytes slice to reset it for next read
-            // used to be in a goroutine but that felt somehow risky..
-            for i, j := 0, n-1; i<=j; i, j = i+1, j-1 {
-                bytes[i], bytes[j] = 0, 0
+        n, err := ft.readFile(bytes)
+        if err != nil {
+            return err
+        }
+        if n == 0 {
+            partial := len(ft.line.partial)
+            if ! ft.flushLine(false) {
+                return nil
+            }
+            if partial > 0 && len(ft.line.partial) == 0 {
+                if err := ft.mark(ft.fh, ft.ino, ft.pos); err != nil {
+                    return err
+                }
             }
-            time.Sleep(time.Duration(loop_zzzZZzz) * time.Millisecond)
+            // idle, nothing is left unwritten
+            if err := ft.cp.flush(); err != nil {
+                return err
+            }
+            ft.wake.wait(ft.flushIn())
+            continue
         }
-    }(ft)
-    
-    return ft
+
+        if ! ft.emit(bytes[:n]) {
+            return nil
+        }
+        if err := ft.mark(ft.fh, ft.ino, ft.pos); err != nil {
+            return err
+        }
+        // zero out the bytes slice to reset it for next read
+        // used to be in a goroutine but that felt somehow risky..
+        for i, j := 0, n-1; i<=j; i, j = i+1, j-1 {
+            bytes[i], bytes[j] = 0, 0
+        }
+        // full buffer, there may be more already
+        if n < len(bytes) {
+            ft.wake.wait(ft.flushIn())
+        }
+    }
+    return nil
 }
@@ -0,0 +1,163 @@
+package fileops
+
+import (
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// inotify picks up writes and rotation well before loop_zzzZZzz
+
+func TestTailInotify(t *testing.T) {
+    p := filepath.Join(t.TempDir(), "log")
+    writeFile(t, p, "old\n")
+
+    ft := NewTail(p)
+    defer ft.Stop()
+    time.Sleep(50 * time.Millisecond)
+
+    d := loop_zzzZZzz / 3 * time.Millisecond
+
+    f := appender(t, p)
+    write(t, f, "a\n")
+    if n := recv(ft, d); n == nil || n.Status() != File_std || n.Data() != "a\n" {
+        t.Fatalf("write: %v", n)
+    }
+
+    if err := os.Rename(p, p+".1"); err != nil {
+        t.Fatal(err)
+    }
+    writeFile(t, p, "new\n")
+    if n := recv(ft, d); n == nil || n.Status() != File_new || n.Data() != "new\n" {
+        t.Fatalf("rotate: %v", n)
+    }
+}
+
+// writer keeps the rotated file open for a while,
+// the rest of it comes before the new file
+
+func TestRotateDrain(t *testing.T) {
+    for _, poll := range []bool{false, true} {
+        p := filepath.Join(t.TempDir(), "log")
+        writeFile(t, p, "")
+
+        ft := NewTail(p, Lines(true), Poll(poll))
+        time.Sleep(50 * time.Millisecond)
+
+        f := appender(t, p)
+        write(t, f, "a\n")
+        expect(t, until(t, ft, "a"), note{File_std, "a"})
+
+        if err := os.Rename(p, p+".1"); err != nil {
+            t.Fatal(err)
+        }
+        write(t, f, "late1\nlate2\n")
+        writeFile(t, p, "new\n")
+        expect(t, until(t, ft, "new"), note{File_drn, "late1"}, note{File_std, "late2"}, note{File_new, "new"})
+
+        ft.Stop()
+    }
+}
+
+// written after our last read, copied away and truncated
+// before we got to it
+
+func TestCopyTruncateDrain(t *testing.T) {
+    p := filepath.Join(t.TempDir(), "log")
+    writeFile(t, p, "")
+
+    ft := NewTail(p, Lines(true), Poll(true))
+    defer ft.Stop()
+    time.Sleep(50 * time.Millisecond)
+
+    // longer than what is written after the truncate,
+    // same size would be a rewrite
+
+    f := appender(t, p)
+    write(t, f, "first\n")
+    expect(t, until(t, ft, "first"), note{File_std, "first"})
+
+    write(t, f, "unread\n")
+    copyFile(t, p, p+".1")
+    if err := f.Truncate(0); err != nil {
+        t.Fatal(err)
+    }
+    write(t, f, "x\n")
+    expect(t, until(t, ft, "x"), note{File_drn, "unread"}, note{File_cut, "x"})
+}
+
+func copyFile(t *testing.T, from, to string) {
+    t.Helper()
+
+    src, err := os.Open(from)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer src.Close()
+
+    dst, err := os.Create(to)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := io.Copy(dst, src); err != nil {
+        t.Fatal(err)
+    }
+    if err := dst.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// file written over with the same size is told by what changed,
+// touch or the same text again is no change
+
+func TestRewrite(t *testing.T) {
+    // past the fingerprint, the end can change alone
+    head := strings.Repeat("x", fp_len) + "\n"
+
+    for _, poll := range []bool{false, true} {
+        p := filepath.Join(t.TempDir(), "log")
+        writeFile(t, p, head + "line2\n")
+
+        ft := NewTail(p, FromStart(), Poll(poll))
+        expect(t, until(t, ft, head + "line2\n"), note{File_std, head + "line2\n"})
+        time.Sleep(20 * time.Millisecond)
+
+        now := time.Now().Add(time.Second)
+        if err := os.Chtimes(p, now, now); err != nil {
+            t.Fatal(err)
+        }
+        overwrite(t, p, head + "line2\n")
+        quiet(t, ft, loop_zzzZZzz * 2 * time.Millisecond)
+
+        // same start, different end
+
+        overwrite(t, p, head + "lineX\n")
+        expect(t, until(t, ft, head + "lineX\n"), note{File_cut, head + "lineX\n"})
+        time.Sleep(20 * time.Millisecond)
+
+        // different start
+
+        overwrite(t, p, "X" + head[1:] + "lineX\n")
+        expect(t, until(t, ft, "X" + head[1:] + "lineX\n"), note{File_new, "X" + head[1:] + "lineX\n"})
+
+        ft.Stop()
+    }
+}
+
+// overwrite writes s over path in place, no truncate
+// for the tail to see in between
+func overwrite(t *testing.T, path, s string) {
+    t.Helper()
+
+    f, err := os.OpenFile(path, os.O_WRONLY, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+
+    write(t, f, s)
+}
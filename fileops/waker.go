@@ -0,0 +1,51 @@
+package fileops
+import (
+    "context"
+    "time"
+)
+
+// waker blocks tail/watch loop until there may be something
+// to look at, true when woken by an event (poller always),
+// false also when ctx is done. Waits no longer than max
+// unless it is 0.
+type waker interface {
+    wait(max time.Duration) bool
+    close()
+}
+
+// poller is the fallback, sleeps d and looks
+type poller struct {
+    ctx context.Context
+    d time.Duration
+}
+func (p poller) wait(max time.Duration) bool {
+    d := p.d
+    if max > 0 && max < d {
+        d = max
+    }
+    t := time.NewTimer(d)
+    defer t.Stop()
+    select {
+        case <-t.C:
+            return true
+        case <-p.ctx.Done():
+            return false
+    }
+}
+func (p poller) close() {}
+
+// newWaker is inotify where available (linux) unless poll,
+// ms is poll interval in milliseconds
+func newWaker(ctx context.Context, path string, poll bool, ms int) waker {
+    if ! poll {
+        if w, err := newInotify(path); err == nil {
+            // unblocks wait()
+            go func() {
+                <-ctx.Done()
+                w.close()
+            }()
+            return w
+        }
+    }
+    return poller{ctx, time.Duration(ms) * time.Millisecond}
+}
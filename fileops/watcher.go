@@ -1,6 +1,10 @@
 package fileops
 import (
-    "time"
+    "context"
+)
+const (
+    // milliseconds
+    watch_zzzZZzz = 1000
 )
 
 type fileChange struct {
@@ -21,8 +25,20 @@ func (fc fileChange) Data() any { return fc.change }
 type fileWatch struct {
     path string
     ino uint64 
-    ctime int64
+    ctime int64 // nanoseconds
     comms chan Notify
+    poll bool
+    runner
+}
+
+// Config
+type WatchConf func(*fileWatch)
+// WatchPoll stats the file every second instead of inotify
+// (eg. network filesystems)
+func WatchPoll(b bool) func(*fileWatch) {
+    return func(fw *fileWatch) {
+        fw.poll = b
+    }
 }
 
 func (fw *fileWatch) Path() string { return fw.path }
@@ -34,22 +50,45 @@ func (fw *fileWatch) Exists() bool {
     }
     return true
 }
-func (fw *fileWatch) updateInode() {
-    stat := stat(fw.path)
+func (fw *fileWatch) updateInode() error {
+    stat, err := stat(fw.path)
+    if err != nil {
+        return err
+    }
     fw.ino = stat.Ino
-    fw.ctime = stat.Ctim.Sec
+    fw.ctime = stat.Ctim.Nano()
+    return nil
+}
+// NewWatcher sends state of path every second when polling,
+// with inotify only when something happened to it
+func NewWatcher(path string, conf ...WatchConf) FileObj {
+    return NewWatcherContext(context.Background(), path, conf...)
 }
-func NewWatcher(path string) FileObj {
-    fw := &fileWatch{path, 0, 0, make(chan Notify)}
+// NewWatcherContext stops watching when ctx is done
+func NewWatcherContext(ctx context.Context, path string, conf ...WatchConf) FileObj {
+    fw := &fileWatch{path, 0, 0, make(chan Notify), false, newRunner(ctx)}
+    // error is for the goroutine to tell
     fw.updateInode()
+    for _, wconf := range conf {
+        wconf(fw)
+    }
 
     go func(fw *fileWatch) {
+        defer close(fw.done)
+        defer close(fw.comms)
+        defer fw.cancel()
+        wake := newWaker(fw.ctx, fw.path, fw.poll, watch_zzzZZzz)
+        defer wake.close()
+
         var ino uint64
         var ctime int64
-        for {
+        for woke := true; fw.ctx.Err() == nil; woke = wake.wait(0) {
             ino = fw.ino
             ctime = fw.ctime
-            fw.updateInode()
+            if err := fw.updateInode(); err != nil {
+                fw.send(fw.comms, fileErr{fw.path, err})
+                return
+            }
 
             // default - no change
             var c uint8 = File_std
@@ -73,8 +112,14 @@ func NewWatcher(path string) FileObj {
                 c = File_chg
             }
 
-            fw.comms <- fileChange{fw.path, c, s}
-            time.Sleep(1 * time.Second)
+            // woken by timeout only (inotify),
+            // tell if inotify missed something
+            if ! woke && c == File_std {
+                continue
+            }
+            if ! fw.send(fw.comms, fileChange{fw.path, c, s}) {
+                return
+            }
         }
     }(fw)
 
@@ -0,0 +1,71 @@
+package fileops
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// change is status of the next change fo tells of within d,
+// polling sends no change every second too
+func change(t *testing.T, fo FileObj, d time.Duration) uint8 {
+    t.Helper()
+
+    for start := time.Now(); time.Since(start) < d; {
+        n := recv(fo, d - time.Since(start))
+        if n == nil {
+            break
+        }
+        if n.Data().(uint8) == File_chg {
+            return n.Status()
+        }
+    }
+    t.Fatal("no change within", d)
+    return 0
+}
+
+func TestWatcher(t *testing.T) {
+    for _, poll := range []bool{false, true} {
+        p := filepath.Join(t.TempDir(), "w")
+        writeFile(t, p, "x")
+
+        fw := NewWatcher(p, WatchPoll(poll))
+
+        // inotify tells right away, poll within its second
+        d := 300 * time.Millisecond
+        if poll {
+            d = 2 * watch_zzzZZzz * time.Millisecond
+        }
+
+        if n := recv(fw, d); n == nil || n.Status() != File_std || n.Data().(uint8) != File_std {
+            t.Fatalf("poll %v: first %v", poll, n)
+        }
+
+        time.Sleep(20 * time.Millisecond)
+        writeFile(t, p, "y")
+        if s := change(t, fw, d); s != File_std {
+            t.Fatalf("poll %v: write %s", poll, statusNames[s])
+        }
+
+        if err := os.Remove(p); err != nil {
+            t.Fatal(err)
+        }
+        if s := change(t, fw, d); s != File_mis {
+            t.Fatalf("poll %v: remove %s", poll, statusNames[s])
+        }
+
+        writeFile(t, p, "z")
+        if s := change(t, fw, d); s != File_new {
+            t.Fatalf("poll %v: create %s", poll, statusNames[s])
+        }
+
+        // nothing more to tell with inotify
+
+        if ! poll {
+            quiet(t, fw, 300 * time.Millisecond)
+        }
+
+        fw.Stop()
+    }
+}
@@ -1,44 +1,201 @@
 package xsock
 
 import (
+    "errors"
     "net"
     "io"
+    "os"
     v2 "vella/v2utils"
 )
 
-func rx(c net.Conn) (*Packet, error) {
-    buf := make([]byte, packetLen)
+// Messages longer than msgLen are split into sequenced packets,
+// each carrying the same header (id, session) plus its own
+// frame (seq, total, plen). The whole message goes out in one write
+// and is read back packet by packet until total is reached.
+// Payload is taken as is (binary safe), plen says how much of it is valid.
 
-    _, err := c.Read(buf)
-    if err != nil { 
-        if err != io.EOF {
-            err = v2.ErrCtx(err.Error(), "rx")
+func rx(c net.Conn, max int) (*Packet, []byte, error) {
+    p, err := rxPacket(c)
+    if err != nil {
+        return p, nil, err
+    }
+
+    if p.GetSeq() != 0 {
+        return nil, nil, errSequence.Ctx("rx")
+    }
+
+    // header is still returned so the
+    // caller can reply to the oversized request
+
+    total := int(p.GetTotal())
+    if total > max {
+        return p, nil, errMsgTooLarge.Ctx("rx")
+    }
+
+    if p.getPlen() != chunkLen(total, 0) {
+        return nil, nil, errLength.Ctx("rx")
+    }
+
+    msg := make([]byte, 0, total)
+    msg = append(msg, p.Payload()...)
+
+    for seq:=1; len(msg)<total; seq++ {
+        f, err := rxPacket(c)
+        if err != nil {
+            switch ; {
+                case err == io.EOF:
+                    err = errFragment.Ctx("rx")
+                case errors.Is(err, errIdle): // mid message
+                    err = errTransmitTimeout.Ctx("rx")
+            }
+
+            return nil, nil, err
         }
 
-        return nil, err
-    }           
+        if f.GetId() != p.GetId() || f.GetSess() != p.GetSess() {
+            return nil, nil, errFragment.Ctx("rx")
+        }
+
+        if f.GetSeq() != uint32(seq) || f.GetTotal() != uint32(total) || f.GetReq() != p.GetReq() {
+            return nil, nil, errSequence.Ctx("rx")
+        }
+
+        if f.getPlen() != chunkLen(total, len(msg)) {
+            return nil, nil, errLength.Ctx("rx")
+        }
 
-    if len(buf) > packetLen {
-        return nil, errBufferOverflow.Ctx("rx")
+        msg = append(msg, f.Payload()...)
+    }
+
+    return p, msg, nil
+}
+
+func tx(c net.Conn, p *Packet, msg []byte, max int) error {
+    if len(msg) > max {
+        return errMsgTooLarge.Ctx("tx")
+    }
+
+    // always at least one packet,
+    // even for empty message (SYN, CLS)
+
+    count := (len(msg) + msgLen - 1) / msgLen
+    if count == 0 {
+        count = 1
+    }
+
+    buf := make([]byte, 0, count*packetLen)
+    for seq:=0; seq<count; seq++ {
+        f := NewPacket()
+        copy(f.Stream[:frameOff], p.Stream[:frameOff])
+        f.SetSeq(uint32(seq))
+        f.SetTotal(uint32(len(msg)))
+        f.SetReq(p.GetReq())
+
+        off := seq*msgLen
+        f.SetPayload(msg[off:off+chunkLen(len(msg), off)])
+
+        buf = append(buf, f.Stream[:]...)
+    }
+
+    _, err := c.Write(buf)
+    if err != nil {
+        if errors.Is(err, os.ErrDeadlineExceeded) {
+            return errTransmitTimeout.Ctx("tx")
+        }
+
+        return v2.ErrCtx(err.Error(), "tx")
     }
 
+    return nil
+}
+
+func rxPacket(c net.Conn) (*Packet, error) {
     p := NewPacket()
-    for c, v := range buf {
-        p.Stream[c] = v
+
+    // stream socket, a single read may return less
+    // than a full packet. Deadline before the first byte
+    // is errIdle (peer had nothing to say), after it
+    // errTransmitTimeout (peer stalled).
+
+    n, err := io.ReadFull(c, p.Stream[:])
+    if err != nil {
+        switch ; {
+            case err == io.EOF:
+            case errors.Is(err, os.ErrDeadlineExceeded) && n == 0:
+                err = errIdle.Ctx("rx")
+            case errors.Is(err, os.ErrDeadlineExceeded):
+                err = errTransmitTimeout.Ctx("rx")
+            default:
+                err = v2.ErrCtx(err.Error(), "rx")
+        }
+
+        return nil, err
     }
 
+    // version mismatch keeps the packet,
+    // caller may want to tell the peer
+
     if _, err = p.IsCorrupt(); err != nil {
+        if errors.Is(err, errVersion) {
+            return p, err
+        }
+
         return nil, errCorrupted.Ctx("rx")
     }
 
     return p, nil
 }
 
-func tx(c net.Conn, p *Packet) error {
-    _, err := c.Write(p.Stream[:])
-    if err != nil {
-        return v2.ErrCtx(err.Error(), "tx")
+func chunkLen(total, off int) int {
+    if total - off > msgLen {
+        return msgLen
     }
 
-    return nil
+    return total - off
+}
+
+// traceConn hands every packet going through the connection
+// to fn, out is true for sent packets
+
+type traceConn struct {
+    net.Conn
+    fn      func(bool, *Packet)
+    rbuf    []byte
+    wbuf    []byte
+}
+
+func traced(c net.Conn, fn func(bool, *Packet)) net.Conn {
+    if fn == nil {
+        return c
+    }
+
+    return &traceConn{Conn: c, fn: fn}
+}
+
+func (t *traceConn) Read(b []byte) (int, error) {
+    n, err := t.Conn.Read(b)
+    t.rbuf = t.trace(false, t.rbuf, b[:n])
+
+    return n, err
+}
+
+func (t *traceConn) Write(b []byte) (int, error) {
+    n, err := t.Conn.Write(b)
+    t.wbuf = t.trace(true, t.wbuf, b[:n])
+
+    return n, err
+}
+
+func (t *traceConn) trace(out bool, buf, b []byte) []byte {
+    buf = append(buf, b...)
+
+    for len(buf) >= packetLen {
+        p := &Packet{}
+        copy(p.Stream[:], buf[:packetLen])
+        t.fn(out, p)
+
+        buf = buf[packetLen:]
+    }
+
+    return buf
 }
@@ -0,0 +1,108 @@
+package xsock
+
+import (
+    "bytes"
+    "errors"
+    "net"
+    "testing"
+)
+
+// roundtrip sends msg over a pipe with tx() and reads it back with rx(),
+// returns the message and number of packets it took
+
+func roundtrip(t *testing.T, p *Packet, msg []byte, max int) ([]byte, int, error) {
+    t.Helper()
+
+    a, b := net.Pipe()
+    defer a.Close()
+    defer b.Close()
+
+    var count int
+    b = traced(b, func(bool, *Packet) { count++ })
+
+    sent := make(chan error, 1)
+    go func() {
+        sent <- tx(a, p, msg, maxMsgCap)
+    }()
+
+    q, m, err := rx(b, max)
+    if err != nil {
+        a.Close() // rest of the message is not read
+        <- sent
+        return nil, count, err
+    }
+
+    if err := <- sent; err != nil {
+        t.Fatal(err)
+    }
+
+    if q.GetReq() != p.GetReq() || q.GetId() != p.GetId() {
+        t.Fatalf("header req %d id %q, sent req %d id %q", q.GetReq(), q.GetIdStr(), p.GetReq(), p.GetIdStr())
+    }
+
+    return m, count, nil
+}
+
+func TestFragments(t *testing.T) {
+    for _, size := range []int{0, 1, msgLen-1, msgLen, msgLen+1, 2*msgLen, 2*msgLen+1, 100*msgLen+7} {
+        msg := make([]byte, size)
+        for i := range msg {
+            msg[i] = byte(i % 251)
+        }
+
+        p := NewPacket(Ack(newId()))
+        p.SetReq(42)
+
+        got, count, err := roundtrip(t, p, msg, maxMsgStd)
+        if err != nil {
+            t.Fatalf("%d bytes: %v", size, err)
+        }
+
+        if !bytes.Equal(got, msg) {
+            t.Fatalf("%d bytes: message changed on the way", size)
+        }
+
+        want := (size + msgLen - 1) / msgLen
+        if want == 0 {
+            want = 1
+        }
+
+        if count != want {
+            t.Fatalf("%d bytes: %d packets, want %d", size, count, want)
+        }
+    }
+}
+
+func TestFragmentsTooLarge(t *testing.T) {
+    p := NewPacket(Ack(newId()))
+
+    _, _, err := roundtrip(t, p, make([]byte, 3*msgLen), 2*msgLen)
+    if !errors.Is(err, errMsgTooLarge) {
+        t.Fatal(err)
+    }
+}
+
+func TestFragmentsOutOfOrder(t *testing.T) {
+    a, b := net.Pipe()
+    defer a.Close()
+    defer b.Close()
+
+    id := newId()
+    total := 2*msgLen
+
+    // second fragment first
+
+    go func() {
+        for _, seq := range []uint32{1, 0} {
+            f := NewPacket(Ack(id))
+            f.SetSeq(seq)
+            f.SetTotal(uint32(total))
+            f.SetPayload(make([]byte, msgLen))
+            a.Write(f.Stream[:])
+        }
+    }()
+
+    if _, _, err := rx(b, maxMsgStd); !errors.Is(err, errSequence) {
+        t.Fatal(err)
+    }
+}
@@ -0,0 +1,89 @@
+package xsock
+
+import (
+    "context"
+    "crypto/tls"
+    "net"
+    "strings"
+)
+
+const (
+    schemeUnix  = "unix://"
+    schemeTcp   = "tcp://"
+    schemeTls   = "tls://"
+)
+
+// addr is parsed server/client address
+//  /run/x.sock, unix:///run/x.sock - unix socket
+//  tcp://host:port                 - plain TCP
+//  tls://host:port                 - TLS over TCP
+
+type addr struct {
+    network string
+    address string
+    tls     bool
+}
+
+func parseAddr(s string) (addr, error) {
+    switch ; {
+        case strings.HasPrefix(s, schemeUnix):
+            s = strings.TrimPrefix(s, schemeUnix)
+        case strings.HasPrefix(s, schemeTcp):
+            return tcpAddr(strings.TrimPrefix(s, schemeTcp), false)
+        case strings.HasPrefix(s, schemeTls):
+            return tcpAddr(strings.TrimPrefix(s, schemeTls), true)
+        case strings.Contains(s, "://"):
+            return addr{}, errScheme.Ctx("xaddr(" + s + ")")
+    }
+
+    if s == "" {
+        return addr{}, errAddress.Ctx("xaddr")
+    }
+
+    return addr{network: "unix", address: s}, nil
+}
+
+func tcpAddr(s string, tls bool) (addr, error) {
+    if _, _, err := net.SplitHostPort(s); err != nil {
+        return addr{}, errAddress.Ctx("xaddr(" + s + ")")
+    }
+
+    return addr{network: "tcp", address: s, tls: tls}, nil
+}
+
+// socket file, unix only
+
+func (a addr) sock() string {
+    if a.network != "unix" {
+        return ""
+    }
+
+    return a.address
+}
+
+func (a addr) listen(conf *tls.Config) (net.Listener, error) {
+    if a.tls && conf == nil {
+        return nil, errTLSConfig.Ctx("xaddr")
+    }
+
+    l, err := net.Listen(a.network, a.address)
+    if err != nil {
+        return nil, err
+    }
+
+    if a.tls {
+        l = tls.NewListener(l, conf)
+    }
+
+    return l, nil
+}
+
+func (a addr) dial(ctx context.Context, conf *tls.Config) (net.Conn, error) {
+    if a.tls {
+        d := &tls.Dialer{Config: conf}
+        return d.DialContext(ctx, a.network, a.address)
+    }
+
+    d := &net.Dialer{}
+    return d.DialContext(ctx, a.network, a.address)
+}
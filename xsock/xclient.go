@@ -1,90 +1,452 @@
 package xsock
 
 import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "io"
     "net"
+    "sync"
     "time"
+    v2 "vella/v2utils"
 )
 
+const (
+    sendTimeout = 3000 // milliseconds, default deadline of calls without context
+)
+
+type clientModifier func(*Client)
+
 type Client struct {
-    Path    string
-    Session [idLen]byte
+    Path        string // address, see parseAddr()
+    Session     [idLen]byte
+    MaxMsg      int
+    TLS         *tls.Config
+    Persistent  bool          // one connection for all requests (pipelined)
+    Timeout     time.Duration // NewClient(), Send(), Close() deadline
+    Trace       func(bool, *Packet) // every packet sent (true) and received (false)
+    Resyn       bool // open new session when current one is invalid/expired
+    Dialer      func(context.Context) (net.Conn, error) // replaces dialing Path
+
+    addr        addr
+
+    mu          sync.Mutex // Session, conn, pending, req, subs
+    conn        net.Conn   // persistent only
+    wmu         sync.Mutex // writes on conn
+    pending     map[uint32]chan cSession
+    req         uint32
+    subs        map[string]chan Event
 }
 
-func NewClient(path string) (*Client, error) {
-    c := &Client{Path: path}
+func NewClient(path string, mods ...clientModifier) (*Client, error) {
+    c := &Client{Path: path, MaxMsg: maxMsgStd, Timeout: time.Duration(sendTimeout) * time.Millisecond}
+    c.pending = make(map[uint32]chan cSession)
+    c.subs = make(map[string]chan Event)
+
+    for _, m := range mods {
+        m(c)
+    }
+
+    if c.Dialer == nil {
+        a, err := parseAddr(path)
+        if err != nil {
+            return nil, err
+        }
+        c.addr = a
+    }
 
-    p, e := c.transmit()
+    ctx, cancel := c.deadline()
+    defer cancel()
+
+    if err := c.syn(ctx); err != nil {
+        return nil, err
+    }
+
+    return c, nil
+}
+
+// syn opens new session
+
+func (c *Client) syn(ctx context.Context) error {
+    p, m, e := c.transmit(ctx)
     if e != nil {
-        return nil, e
+        return e
+    }
+
+    if !p.GetExitCode() {
+        return v2.ErrCtx(string(m), "xclient")
     }
 
     c.SetSession(p)
-    return c, nil
+    return nil
 }
 
 func (c *Client) Send(s string) (string, error) {
-    p, err := c.transmit(s)
+    ctx, cancel := c.deadline()
+    defer cancel()
+
+    return c.SendContext(ctx, s)
+}
+
+func (c *Client) SendContext(ctx context.Context, s string) (string, error) {
+    msg, _, err := c.Exec(ctx, s)
+    return msg, err
+}
+
+// Exec is SendContext() plus exit code of the reply (ExitOK, ExitFail, ...)
+
+func (c *Client) Exec(ctx context.Context, s string) (string, uint8, error) {
+    if c.Resyn && !c.ActiveSession() {
+        if err := c.syn(ctx); err != nil {
+            return "", 0, err
+        }
+    }
+
+    p, msg, err := c.transmit(ctx, s)
     if err != nil {
-        return "", err
+        return "", 0, err
+    }
+
+    // session expired or server restarted (and could not resume),
+    // open new one and try again
+
+    if p.GetExit() == ExitInvalid && c.Resyn {
+        c.mu.Lock()
+        if c.Session == p.GetId() {
+            c.Session = [idLen]byte{}
+        }
+        c.mu.Unlock()
+
+        if err := c.syn(ctx); err != nil {
+            return "", 0, err
+        }
+
+        p, msg, err = c.transmit(ctx, s)
+        if err != nil {
+            return "", 0, err
+        }
     }
 
-    if !c.ActiveSession() {
-        c.SetSession(p)
+    c.mu.Lock()
+    if !c.active() {
+        c.setSession(p)
     }
+    c.mu.Unlock()
 
-    return p.GetMsg(), nil
+    return string(msg), p.GetExit(), nil
 }
 
 type cSession struct {
     p *Packet
+    m []byte
     e error
 }
 
-func (c *Client) transmit(s ...string) (*Packet, error) {
-    conn, err := net.Dial("unix", c.Path)
-    if err != nil {
-        return nil, err
-    }
-    defer conn.Close()
-
+func (c *Client) transmit(ctx context.Context, s ...string) (*Packet, []byte, error) {
     // NewPacket() returns SYN
     // if active session make it ACK
 
     p := NewPacket()
-    if c.ActiveSession() {
+    var msg []byte
+
+    c.mu.Lock()
+    if c.active() && len(s) > 0 {
         Ack(c.Session)(p)
-        p.SetMsg(s[0])
+        msg = []byte(s[0])
+    }
+    c.req++
+    p.SetReq(c.req)
+    c.mu.Unlock()
+
+    if len(msg) > c.MaxMsg {
+        return nil, nil, errMsgTooLarge.Ctx("xclient")
+    }
+
+    if c.Persistent {
+        return c.pipeline(ctx, p, msg)
+    }
+
+    return c.oneshot(ctx, p, msg)
+}
+
+// oneshot is a connection per request
+
+func (c *Client) oneshot(ctx context.Context, p *Packet, msg []byte) (*Packet, []byte, error) {
+    conn, err := c.dial(ctx)
+    if err != nil {
+        return nil, nil, err
     }
+    defer conn.Close()
 
-    rcv := make(chan cSession)
+    // server not reading (workers busy) would hold
+    // the write past ctx, connection is ours alone
+
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    defer stop()
+
+    rcv := make(chan cSession, 1)
     go func() {
-        p, e := rx(conn)
-        rcv <- cSession{p, e}           // reply listener
+        p, m, e := rx(conn, c.MaxMsg)
+        rcv <- cSession{p, m, e}        // reply listener
         close(rcv)
     }()
 
-    err = tx(conn, p)                   // sender
+    err = tx(conn, p, msg, c.MaxMsg)    // sender
     if err != nil {
-        return nil, err
+        if ctx.Err() != nil {
+            return nil, nil, ctxErr(ctx)
+        }
+
+        return nil, nil, err
     }
 
     var sess cSession
     select {
-        case sess = <- rcv:             // response received (from reply listener, blocking channel - timeout below)
-        case <- time.After(time.Duration(3) * time.Second):
-            return nil, errTransmitTimeout.Ctx("xclient")
+        case sess = <- rcv:             // response received (from reply listener, blocking channel - ctx below)
+        case <- ctx.Done():
+            return nil, nil, ctxErr(ctx)
     }
 
-    return sess.p, sess.e
+    return sess.p, sess.m, sess.e
+}
+
+// pipeline sends on the shared connection and waits for the reply
+// with the same request id, replies are read by reader()
+
+func (c *Client) pipeline(ctx context.Context, p *Packet, msg []byte) (*Packet, []byte, error) {
+    req := p.GetReq()
+
+    for retry:=true; ; retry=false {
+        conn, rcv, err := c.enqueue(ctx, req)
+        if err != nil {
+            return nil, nil, err
+        }
+
+        // write is cut short when ctx is done, part of the
+        // message may be out already, connection can't be used after
+
+        c.wmu.Lock()
+        if ctx.Err() != nil { // waited for other writer
+            c.wmu.Unlock()
+            c.dequeue(req)
+
+            return nil, nil, ctxErr(ctx)
+        }
+
+        stop := context.AfterFunc(ctx, func() { conn.SetWriteDeadline(time.Now()) })
+        err = tx(conn, p, msg, c.MaxMsg)
+        cut := !stop()
+        c.wmu.Unlock()
+
+        if cut {
+            c.drop(conn, ctxErr(ctx))
+            return nil, nil, ctxErr(ctx)
+        }
+
+        if err != nil {
+            // stale connection (server went away while we were idle),
+            // request did not make it, try once more on a fresh one
+
+            c.drop(conn, err)
+            if retry {
+                continue
+            }
+
+            return nil, nil, err
+        }
+
+        select {
+            case sess := <- rcv:
+                return sess.p, sess.m, sess.e
+            case <- ctx.Done():
+                c.dequeue(req)
+                return nil, nil, ctxErr(ctx)
+        }
+    }
+}
+
+func (c *Client) enqueue(ctx context.Context, req uint32) (net.Conn, chan cSession, error) {
+    for {
+        c.mu.Lock()
+        if conn := c.conn; conn != nil {
+            rcv := make(chan cSession, 1)
+            c.pending[req] = rcv
+            c.mu.Unlock()
+
+            return conn, rcv, nil
+        }
+        c.mu.Unlock()
+
+        if err := c.connect(ctx); err != nil {
+            return nil, nil, err
+        }
+    }
+}
+
+// connect dials the shared connection without holding c.mu,
+// callers racing here keep the first one in
+
+func (c *Client) connect(ctx context.Context) error {
+    conn, err := c.dial(ctx)
+    if err != nil {
+        return err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.conn != nil {
+        conn.Close()
+        return nil
+    }
+
+    c.conn = conn
+    go c.reader(conn)
+
+    return nil
+}
+
+func (c *Client) dequeue(req uint32) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    delete(c.pending, req)
+}
+
+// reader hands replies on the shared connection to their callers
+// until the connection breaks
+
+func (c *Client) reader(conn net.Conn) {
+    for {
+        p, m, err := rx(conn, c.MaxMsg)
+        if err != nil {
+            if err == io.EOF {
+                err = errConnClosed.Ctx("xclient")
+            }
+
+            c.drop(conn, err)
+            return
+        }
+
+        // server is going away (session ended with it)
+        // or closing idle connection (session stays)
+
+        if p.IsCls() {
+            if p.GetExit() != ExitInvalid {
+                c.drop(conn, errConnClosed.Ctx("xclient"))
+                return
+            }
+
+            c.mu.Lock()
+            if c.Session == p.GetId() {
+                c.Session = [idLen]byte{}
+            }
+            c.mu.Unlock()
+
+            c.drop(conn, errSessionClosed.Ctx("xclient"))
+            return
+        }
+
+        if p.IsEvt() {
+            c.mu.Lock()
+            c.event(m)
+            c.mu.Unlock()
+
+            continue
+        }
+
+        c.mu.Lock()
+        rcv, ok := c.pending[p.GetReq()]
+        delete(c.pending, p.GetReq())
+        c.mu.Unlock()
+
+        if ok {
+            rcv <- cSession{p, m, nil}
+        }
+    }
+}
+
+// drop closes the shared connection and fails everybody waiting on it,
+// next request dials a new one
+
+func (c *Client) drop(conn net.Conn, err error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.conn != conn {
+        return // already dropped
+    }
+
+    conn.Close()
+    c.conn = nil
+
+    for req, rcv := range c.pending {
+        rcv <- cSession{e: err}
+        delete(c.pending, req)
+    }
+
+    // subscriptions lived on this connection
+
+    for topic, ch := range c.subs {
+        close(ch)
+        delete(c.subs, topic)
+    }
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+    dial := func(ctx context.Context) (net.Conn, error) {
+        return c.addr.dial(ctx, c.TLS)
+    }
+
+    if c.Dialer != nil {
+        dial = c.Dialer
+    }
+
+    conn, err := dial(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    return traced(conn, c.Trace), nil
+}
+
+func (c *Client) deadline() (context.Context, context.CancelFunc) {
+    return context.WithTimeout(context.Background(), c.Timeout)
+}
+
+// ctxErr matches both ctx.Err() and, past deadline, errTransmitTimeout
+
+func ctxErr(ctx context.Context) error {
+    if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+        return wrapCtx(errTransmitTimeout.Ctx("xclient"), ctx.Err())
+    }
+
+    return wrapCtx(v2.ErrCtx(ctx.Err().Error(), "xclient"), ctx.Err())
 }
 
 func (c *Client) SetSession(p *Packet) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.setSession(p)
+}
+
+func (c *Client) ActiveSession() bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    return c.active()
+}
+
+// unlocked versions, caller holds c.mu
+
+func (c *Client) setSession(p *Packet) {
     for count:=0; count<int(idLen); count++ {
         c.Session[count] = p.Stream[count]
-    }    
+    }
 }
 
-func (c *Client) ActiveSession() bool {
+func (c *Client) active() bool {
     // is ValidHeaderId(c.Session) needed here,
     // we should either have none or valid one..?
 
@@ -96,20 +458,98 @@ func (c *Client) ActiveSession() bool {
 }
 
 func (c *Client) Close() error {
-    conn, err := net.Dial("unix", c.Path)
-    if err != nil {
-        return err
-    }
-    defer conn.Close()
+    ctx, cancel := c.deadline()
+    defer cancel()
 
     p := NewPacket()
+
+    c.mu.Lock()
     Cls(c.Session)(p)
+    conn := c.conn
+    c.mu.Unlock()
+
+    var err error
+
+    if conn != nil {
+        c.wmu.Lock()
+        err = tx(conn, p, nil, c.MaxMsg)
+        c.wmu.Unlock()
+
+        c.drop(conn, errConnClosed.Ctx("xclient"))
+    } else {
+        conn, err = c.dial(ctx)
+        if err != nil {
+            return err
+        }
+        defer conn.Close()
+
+        err = tx(conn, p, nil, c.MaxMsg)
+    }
 
-    err = tx(conn, p)
     if err != nil {
         return err
     }
 
+    c.mu.Lock()
     c.Session = [idLen]byte{}
+    c.mu.Unlock()
+
     return nil
 }
+
+
+//
+// Modifiers
+
+// ClientTLS is used for tls:// addresses, set Certificates
+// for mutual TLS, RootCAs for private CA
+
+func ClientTLS(conf *tls.Config) clientModifier {
+    return func(c *Client) {
+        c.TLS = conf
+    }
+}
+
+func ClientMaxMsg(max int) clientModifier {
+    if max < 1 || max > maxMsgCap {
+        panic(errMaxMsg.Ctx("xclient"))
+    }
+
+    return func(c *Client) {
+        c.MaxMsg = max
+    }
+}
+
+func ClientTimeout(d time.Duration) clientModifier {
+    if d <= 0 {
+        panic(errTimeout.Ctx("xclient"))
+    }
+
+    return func(c *Client) {
+        c.Timeout = d
+    }
+}
+
+func ClientTrace(fn func(bool, *Packet)) clientModifier {
+    return func(c *Client) {
+        c.Trace = fn
+    }
+}
+
+func Dialer(fn func(context.Context) (net.Conn, error)) clientModifier {
+    return func(c *Client) {
+        c.Dialer = fn
+    }
+}
+
+func Resyn(b bool) clientModifier {
+    return func(c *Client) {
+        c.Resyn = b
+    }
+}
+
+func Persistent(b bool) clientModifier {
+    return func(c *Client) {
+        c.Persistent = b
+    }
+}
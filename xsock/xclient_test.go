@@ -0,0 +1,167 @@
+package xsock
+
+import (
+    "context"
+    "errors"
+    "net"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// silentClient has a session on a real server, requests after
+// silence() go to a peer that never reads (shared connection
+// of persistent client is dropped)
+
+func silentClient(t *testing.T, mods ...clientModifier) (*Client, func()) {
+    t.Helper()
+
+    _, path := serve(t, echo)
+
+    var silent atomic.Bool
+    dial := func(ctx context.Context) (net.Conn, error) {
+        if !silent.Load() {
+            d := &net.Dialer{}
+            return d.DialContext(ctx, "unix", path)
+        }
+
+        a, b := net.Pipe()
+        t.Cleanup(func() { b.Close() })
+
+        return a, nil
+    }
+
+    c, err := NewClient(path, append(mods, Dialer(dial))...)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return c, func() {
+        silent.Store(true)
+
+        c.mu.Lock()
+        conn := c.conn
+        c.mu.Unlock()
+
+        if conn != nil {
+            c.drop(conn, errConnClosed.Ctx("xclient"))
+        }
+    }
+}
+
+// within fails the test if fn runs past d
+
+func within(t *testing.T, d time.Duration, fn func()) {
+    t.Helper()
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        fn()
+    }()
+
+    select {
+        case <- done:
+        case <- time.After(d):
+            t.Fatalf("still blocked after %v", d)
+    }
+}
+
+func TestWriteDeadline(t *testing.T) {
+    for _, persistent := range []bool{false, true} {
+        c, silence := silentClient(t, Persistent(persistent))
+        silence()
+
+        within(t, time.Second, func() {
+            ctx, cancel := context.WithTimeout(context.Background(), 200 * time.Millisecond)
+            defer cancel()
+
+            _, err := c.SendContext(ctx, "x")
+            if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, errTransmitTimeout) {
+                t.Errorf("persistent %v: %v", persistent, err)
+            }
+        })
+    }
+}
+
+func TestWriteCancel(t *testing.T) {
+    c, silence := silentClient(t)
+    silence()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(100 * time.Millisecond, cancel)
+
+    within(t, time.Second, func() {
+        if _, err := c.SendContext(ctx, "x"); !errors.Is(err, context.Canceled) {
+            t.Error(err)
+        }
+    })
+}
+
+// stuck writer on the shared connection
+// does not hold the others past their deadline
+
+func TestWriteDeadlinePipelined(t *testing.T) {
+    c, silence := silentClient(t, Persistent(true))
+    silence()
+
+    within(t, time.Second, func() {
+        var wg sync.WaitGroup
+        for i:=0; i<4; i++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+
+                ctx, cancel := context.WithTimeout(context.Background(), 200 * time.Millisecond)
+                defer cancel()
+
+                if _, err := c.SendContext(ctx, "x"); err == nil {
+                    t.Error("reply from silent peer")
+                }
+            }()
+        }
+
+        wg.Wait()
+    })
+}
+
+// dial in progress does not hold the client lock
+
+func TestDialUnlocked(t *testing.T) {
+    _, path := serve(t, echo)
+
+    var block atomic.Bool
+    dialing := make(chan struct{})
+    release := make(chan struct{})
+
+    dial := func(ctx context.Context) (net.Conn, error) {
+        if block.Load() {
+            close(dialing)
+            <- release
+        }
+
+        d := &net.Dialer{}
+        return d.DialContext(ctx, "unix", path)
+    }
+
+    c, err := NewClient(path, Persistent(true), Dialer(dial))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer close(release)
+
+    c.mu.Lock()
+    conn := c.conn
+    c.mu.Unlock()
+
+    c.drop(conn, errConnClosed.Ctx("xclient"))
+    block.Store(true)
+
+    go c.Send("x")
+    <- dialing
+
+    within(t, time.Second, func() {
+        c.ActiveSession()
+    })
+}
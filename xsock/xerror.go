@@ -17,4 +17,52 @@ var (
     errTTL              = v2.Err("TTL out of range")
     errMissingHandler   = v2.Err("handler not defined")
     errCorrupted        = v2.Err("corrupted")
+    errMsgTooLarge      = v2.Err("message too large")
+    errMaxMsg           = v2.Err("max message size out of range")
+    errSequence         = v2.Err("invalid fragment sequence")
+    errMaxSessions      = v2.Err("max sessions out of range")
+    errWorkers          = v2.Err("workers out of range")
+    errDenied           = v2.Err("permission denied")
+    errGroup            = v2.Err("unknown group")
+    errNoPeerCred       = v2.Err("peer credentials not supported")
+    errScheme           = v2.Err("unsupported address scheme")
+    errAddress          = v2.Err("invalid address")
+    errTLSConfig        = v2.Err("TLS config required")
+    errTimeout          = v2.Err("timeout out of range")
+    errConnClosed       = v2.Err("connection closed")
+    errSessionClosed    = v2.Err("session closed by server")
+    errCall             = v2.Err("invalid call")
+    errCommand          = v2.Err("unknown command")
+    errDuplicateCmd     = v2.Err("command already registered")
+    errArgs             = v2.Err("invalid arguments")
+    errVersion          = v2.Err("incompatible protocol version")
+    errTopic            = v2.Err("invalid topic")
+    errNotPersistent    = v2.Err("persistent connection required")
+    errSubscribed       = v2.Err("already subscribed")
+    errBuiltin          = v2.Err("builtin command failed")
+    errEventQueue       = v2.Err("event queue out of range")
+    errSessionKey       = v2.Err("empty session key")
+    errFragment         = v2.Err("incomplete or mismatched fragment")
+    errStats            = v2.Err("stats not available")
+    errSessionEnd       = v2.Err("session closed")
+    errRate             = v2.Err("rate or burst out of range")
+    errRateLimit        = v2.Err("rate limit exceeded")
+    errSessionsFull     = v2.Err("too many sessions")
+    errIdle             = v2.Err("no request within deadline")
 )
+
+// ctxError is v2 error (message, context) which also matches
+// the context error behind it, errors.Is(err, context.Canceled)
+
+type ctxError struct {
+    err     *v2.Error
+    cause   error
+}
+
+func (e ctxError) Error() string         { return e.err.Error() }
+func (e ctxError) Is(target error) bool  { return e.err.Is(target) }
+func (e ctxError) Unwrap() error         { return e.cause }
+
+func wrapCtx(err *v2.Error, cause error) error {
+    return ctxError{err, cause}
+}
@@ -0,0 +1,287 @@
+package xsock
+
+import (
+    "bytes"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Events are pushed by the server to subscribed sessions as EVT packets,
+// payload is topic, NUL, data. Subscribing needs a persistent client
+// as events travel on the connection the subscription came from.
+//  __sub <topic>   - subscribe
+//  __unsub <topic> - unsubscribe
+
+const (
+    cmdSub      = "__sub "
+    cmdUnsub    = "__unsub "
+    evtQueue    = 64 // events waiting per subscriber
+
+    // slow subscriber (queue full) policy
+    SlowDrop    uint8 = 0 // event is dropped for that subscriber
+    SlowClose   uint8 = 1 // subscriber's connection is closed
+)
+
+type Event struct {
+    Topic   string
+    Data    []byte
+}
+
+type subscriber struct {
+    sc      *sconn
+    id      [idLen]byte
+    topics  map[string]bool
+    queue   chan []byte
+    dropped int
+}
+
+type pubsub struct {
+    sync.Mutex
+    topics  map[string]map[*subscriber]struct{}
+    subs    map[*sconn]*subscriber
+}
+
+func newPubsub() *pubsub {
+    return &pubsub{
+        topics: make(map[string]map[*subscriber]struct{}),
+        subs:   make(map[*sconn]*subscriber),
+    }
+}
+
+// Publish queues data for every subscriber of topic,
+// returns how many got it
+
+func (s *Server) Publish(topic string, data []byte) int {
+    payload := append([]byte(topic + "\x00"), data...)
+    if len(payload) > s.MaxMsg {
+        return 0
+    }
+
+    var slow []*subscriber
+    var n int
+
+    s.events.Lock()
+    for sub := range s.events.topics[topic] {
+        select {
+            case sub.queue <- payload:
+                n++
+            default:
+                sub.dropped++
+                slow = append(slow, sub)
+        }
+    }
+    s.events.Unlock()
+
+    if s.SlowPolicy == SlowClose {
+        for _, sub := range slow {
+            sub.sc.Close() // handle() cleans up
+        }
+    }
+
+    return n
+}
+
+func (s *Server) subscribe(sc *sconn, id [idLen]byte, topic string) error {
+    if topic == "" || strings.ContainsRune(topic, 0) {
+        return errTopic.Ctx("xevent")
+    }
+
+    s.events.Lock()
+    defer s.events.Unlock()
+
+    sub, ok := s.events.subs[sc]
+    if !ok {
+        sub = &subscriber{sc: sc, id: id, topics: make(map[string]bool), queue: make(chan []byte, s.EventQueue)}
+        s.events.subs[sc] = sub
+
+        go s.deliver(sub)
+    }
+
+    sub.topics[topic] = true
+
+    if s.events.topics[topic] == nil {
+        s.events.topics[topic] = make(map[*subscriber]struct{})
+    }
+    s.events.topics[topic][sub] = struct{}{}
+
+    return nil
+}
+
+func (s *Server) unsubscribe(sc *sconn, topic string) {
+    s.events.Lock()
+    defer s.events.Unlock()
+
+    sub, ok := s.events.subs[sc]
+    if !ok {
+        return
+    }
+
+    delete(sub.topics, topic)
+    s.events.unlink(sub, topic)
+
+    if len(sub.topics) == 0 {
+        s.events.remove(sub)
+    }
+}
+
+// deliver writes queued events until the subscriber is removed
+
+func (s *Server) deliver(sub *subscriber) {
+    for payload := range sub.queue {
+        p := NewPacket(setId(sub.id))
+        p.SetEvt()
+
+        err := sub.sc.send(p, payload, s.MaxMsg, time.Duration(evtWait) * time.Millisecond)
+
+        if err != nil {
+            s.failure(err)
+            sub.sc.Close()
+            return
+        }
+    }
+}
+
+func (ps *pubsub) subscribed(sc *sconn) bool {
+    ps.Lock()
+    defer ps.Unlock()
+
+    _, ok := ps.subs[sc]
+    return ok
+}
+
+// dropConn removes subscriptions made on sc (connection closed)
+
+func (ps *pubsub) dropConn(sc *sconn) {
+    ps.Lock()
+    defer ps.Unlock()
+
+    if sub, ok := ps.subs[sc]; ok {
+        ps.remove(sub)
+    }
+}
+
+// dropSession removes subscriptions of session sid (closed, expired)
+
+func (ps *pubsub) dropSession(sid string) {
+    ps.Lock()
+    defer ps.Unlock()
+
+    for _, sub := range ps.subs {
+        if string(sub.id[:]) == sid {
+            ps.remove(sub)
+        }
+    }
+}
+
+// remove and unlink expect the lock held
+
+func (ps *pubsub) remove(sub *subscriber) {
+    for topic := range sub.topics {
+        ps.unlink(sub, topic)
+    }
+
+    delete(ps.subs, sub.sc)
+    close(sub.queue)
+}
+
+func (ps *pubsub) unlink(sub *subscriber, topic string) {
+    delete(ps.topics[topic], sub)
+    if len(ps.topics[topic]) == 0 {
+        delete(ps.topics, topic)
+    }
+}
+
+func splitEvent(payload []byte) Event {
+    i := bytes.IndexByte(payload, 0)
+    if i < 0 {
+        return Event{Data: payload}
+    }
+
+    return Event{Topic: string(payload[:i]), Data: payload[i+1:]}
+}
+
+
+//
+// Client side
+
+// Subscribe returns channel of events published on topic, closed
+// on Unsubscribe() or when the connection goes away. Events are
+// dropped if the channel is not read fast enough.
+
+func (c *Client) Subscribe(topic string) (<-chan Event, error) {
+    if !c.Persistent {
+        return nil, errNotPersistent.Ctx("xclient")
+    }
+
+    // register before asking, first event
+    // may come right after the reply
+
+    ch := make(chan Event, evtQueue)
+
+    c.mu.Lock()
+    if _, ok := c.subs[topic]; ok {
+        c.mu.Unlock()
+        return nil, errSubscribed.Ctx("xclient(" + topic + ")")
+    }
+    c.subs[topic] = ch
+    c.mu.Unlock()
+
+    err := c.builtin(cmdSub + topic)
+    if err != nil {
+        c.mu.Lock()
+        if c.subs[topic] == ch {
+            delete(c.subs, topic)
+            close(ch)
+        }
+        c.mu.Unlock()
+
+        return nil, err
+    }
+
+    return ch, nil
+}
+
+func (c *Client) Unsubscribe(topic string) error {
+    c.mu.Lock()
+    if ch, ok := c.subs[topic]; ok {
+        delete(c.subs, topic)
+        close(ch)
+    }
+    c.mu.Unlock()
+
+    return c.builtin(cmdUnsub + topic)
+}
+
+func (c *Client) builtin(cmd string) error {
+    ctx, cancel := c.deadline()
+    defer cancel()
+
+    msg, code, err := c.Exec(ctx, cmd)
+    if err != nil {
+        return err
+    }
+
+    if code != ExitOK {
+        return errBuiltin.Ctx("xclient(" + msg + ")")
+    }
+
+    return nil
+}
+
+// event hands EVT packet over to its subscription,
+// caller holds c.mu
+
+func (c *Client) event(payload []byte) {
+    ev := splitEvent(payload)
+
+    ch, ok := c.subs[ev.Topic]
+    if !ok {
+        return
+    }
+
+    select {
+        case ch <- ev:
+        default:
+    }
+}
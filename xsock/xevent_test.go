@@ -0,0 +1,99 @@
+package xsock
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func subscribe(t *testing.T, path, topic string) (*Client, <-chan Event) {
+    t.Helper()
+
+    c, err := NewClient(path, Persistent(true))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ch, err := c.Subscribe(topic)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return c, ch
+}
+
+func TestPublish(t *testing.T) {
+    s, path := serve(t, echo)
+
+    c, ch := subscribe(t, path, "news")
+
+    if n := s.Publish("news", []byte("a\x00b")); n != 1 {
+        t.Fatalf("published to %d", n)
+    }
+
+    s.Publish("other", []byte("x"))
+
+    select {
+        case ev := <- ch:
+            if ev.Topic != "news" || string(ev.Data) != "a\x00b" {
+                t.Fatalf("%q %q", ev.Topic, ev.Data)
+            }
+        case <- time.After(time.Second):
+            t.Fatal("no event")
+    }
+
+    if err := c.Unsubscribe("news"); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, ok := <- ch; ok {
+        t.Fatal("channel open after Unsubscribe()")
+    }
+
+    if n := s.Publish("news", nil); n != 0 {
+        t.Fatalf("published to %d after Unsubscribe()", n)
+    }
+}
+
+func TestSubscribeOneshot(t *testing.T) {
+    _, path := serve(t, echo)
+
+    c, err := NewClient(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := c.Subscribe("news"); !errors.Is(err, errNotPersistent) {
+        t.Fatal(err)
+    }
+}
+
+// subscribers do not hold workers, others get served
+
+func TestSubscribersWorkers(t *testing.T) {
+    s, path := serve(t, echo, Workers(1))
+
+    _, ch1 := subscribe(t, path, "news")
+    _, ch2 := subscribe(t, path, "news")
+
+    c, err := NewClient(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := c.Send("x"); err != nil {
+        t.Fatal(err)
+    }
+
+    if n := s.Publish("news", []byte("x")); n != 2 {
+        t.Fatalf("published to %d", n)
+    }
+
+    for _, ch := range []<-chan Event{ch1, ch2} {
+        select {
+            case <- ch:
+            case <- time.After(time.Second):
+                t.Fatal("no event")
+        }
+    }
+}
@@ -0,0 +1,115 @@
+package xsock
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "time"
+)
+
+// Session ids are idLen chars from idPool, crypto/rand backed.
+// With a server key (see SessionKey()) the id is a signed token
+// the server can validate without having it in its session map,
+// so sessions survive server restart:
+//  ts(6)   - issue time, unix seconds base62
+//  rnd(12) - random
+//  sig(14) - HMAC-SHA256(key, ts+rnd) mapped onto idPool
+
+const (
+    tokTsLen    = 6
+    tokRndLen   = 12
+    tokSigOff   = tokTsLen + tokRndLen
+)
+
+// randomId fills b with random idPool chars,
+// bytes past the largest multiple of len(idPool) are
+// thrown away to keep the distribution flat
+
+func randomId(b []byte) {
+    limit := byte(256 - 256 % len(idPool))
+    buf := make([]byte, len(b)*2)
+
+    for c:=0; c<len(b); {
+        if _, err := rand.Read(buf); err != nil {
+            panic(err)
+        }
+
+        for _, r := range buf {
+            if r >= limit {
+                continue
+            }
+
+            b[c] = idPool[int(r) % len(idPool)]
+            c++
+
+            if c == len(b) {
+                break
+            }
+        }
+    }
+}
+
+func newId() [idLen]byte {
+    var id [idLen]byte
+    randomId(id[:])
+
+    return id
+}
+
+func signedId(key []byte, now time.Time) [idLen]byte {
+    var id [idLen]byte
+
+    ts := now.Unix()
+    for c:=tokTsLen-1; c>=0; c-- {
+        id[c] = idPool[ts % int64(len(idPool))]
+        ts /= int64(len(idPool))
+    }
+
+    randomId(id[tokTsLen:tokSigOff])
+    copy(id[tokSigOff:], signature(key, id[:tokSigOff]))
+
+    return id
+}
+
+// verifyId checks signature, returns issue time
+
+func verifyId(key []byte, id [idLen]byte) (time.Time, bool) {
+    if !hmac.Equal(signature(key, id[:tokSigOff]), id[tokSigOff:]) {
+        return time.Time{}, false
+    }
+
+    var ts int64
+    for c:=0; c<tokTsLen; c++ {
+        i := indexPool(id[c])
+        if i < 0 {
+            return time.Time{}, false
+        }
+
+        ts = ts * int64(len(idPool)) + int64(i)
+    }
+
+    return time.Unix(ts, 0), true
+}
+
+func signature(key, b []byte) []byte {
+    m := hmac.New(sha256.New, key)
+    m.Write(b)
+    sum := m.Sum(nil)
+
+    sig := make([]byte, idLen - tokSigOff)
+    for c := range sig {
+        sig[c] = idPool[int(sum[c]) % len(idPool)]
+    }
+
+    return sig
+}
+
+func indexPool(b byte) int {
+    for i:=0; i<len(idPool); i++ {
+        if idPool[i] == b {
+            return i
+        }
+    }
+
+    return -1
+}
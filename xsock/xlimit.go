@@ -0,0 +1,94 @@
+package xsock
+
+import (
+    "net"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// limiter is a token bucket per peer, rate tokens a second
+// up to burst. Peers are told apart by uid when credentials
+// are known, remote host otherwise (see peerKey()).
+
+type bucket struct {
+    tokens  float64
+    last    time.Time
+}
+
+type limiter struct {
+    mu      sync.Mutex
+    rate    float64
+    burst   int
+    buckets map[string]*bucket
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+    return &limiter{rate: rate, burst: burst, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from key's bucket,
+// nil limiter allows everything
+
+func (l *limiter) allow(key string) bool {
+    if l == nil {
+        return true
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := time.Now()
+
+    b, ok := l.buckets[key]
+    if !ok {
+        b = &bucket{tokens: float64(l.burst), last: now}
+        l.buckets[key] = b
+    }
+
+    b.tokens += now.Sub(b.last).Seconds() * l.rate
+    if b.tokens > float64(l.burst) {
+        b.tokens = float64(l.burst)
+    }
+    b.last = now
+
+    if b.tokens < 1 {
+        return false
+    }
+
+    b.tokens--
+    return true
+}
+
+// prune drops buckets that filled up again,
+// same as not having one
+
+func (l *limiter) prune() {
+    if l == nil {
+        return
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    full := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+
+    now := time.Now()
+    for key, b := range l.buckets {
+        if now.Sub(b.last) >= full {
+            delete(l.buckets, key)
+        }
+    }
+}
+
+func peerKey(p Peer) string {
+    if p.Cred {
+        return "uid:" + strconv.FormatUint(uint64(p.Uid), 10)
+    }
+
+    if host, _, err := net.SplitHostPort(p.Addr); err == nil {
+        return "addr:" + host
+    }
+
+    return "addr:" + p.Addr
+}
@@ -0,0 +1,90 @@
+package xsock
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestLimiter(t *testing.T) {
+    l := newLimiter(10, 2)
+
+    for i, want := range []bool{true, true, false} {
+        if l.allow("a") != want {
+            t.Fatalf("call %d allowed %v", i, !want)
+        }
+    }
+
+    if !l.allow("b") {
+        t.Fatal("peers share bucket")
+    }
+
+    time.Sleep(150 * time.Millisecond)
+    if !l.allow("a") {
+        t.Fatal("bucket not refilled")
+    }
+
+    var off *limiter
+    if !off.allow("a") {
+        t.Fatal("nil limiter denied")
+    }
+}
+
+func TestMsgRate(t *testing.T) {
+    s, path := serve(t, echo, MsgRate(0.1, 2))
+
+    c, err := NewClient(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for i, want := range []uint8{ExitOK, ExitOK, ExitRejected} {
+        _, code, err := c.Exec(context.Background(), "x")
+        if err != nil || code != want {
+            t.Fatalf("message %d: exit %d, %v", i, code, err)
+        }
+    }
+
+    if snap, _ := s.Stats(); snap.Counters[MetricRejected] != 1 {
+        t.Fatalf("%d rejected", snap.Counters[MetricRejected])
+    }
+}
+
+func TestSynRate(t *testing.T) {
+    _, path := serve(t, echo, SynRate(0.1, 1))
+
+    if _, err := NewClient(path); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := NewClient(path); err == nil {
+        t.Fatal("second session within rate")
+    }
+}
+
+func TestMaxSessions(t *testing.T) {
+    for _, reject := range []bool{false, true} {
+        _, path := serve(t, echo, MaxSessions(1), RejectFull(reject))
+
+        first, err := NewClient(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        _, err = NewClient(path)
+        if reject != (err != nil) {
+            t.Fatalf("reject %v: %v", reject, err)
+        }
+
+        // evicted or still there
+
+        want := ExitOK
+        if !reject {
+            want = ExitInvalid
+        }
+
+        if _, code, err := first.Exec(context.Background(), "x"); err != nil || code != want {
+            t.Fatalf("reject %v: exit %d, %v", reject, code, err)
+        }
+    }
+}
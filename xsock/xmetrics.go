@@ -0,0 +1,330 @@
+package xsock
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "sync"
+    v2 "vella/v2utils"
+)
+
+// Server reports what it does to Metrics, Stats (default) keeps it
+// in memory, exports it in Prometheus text format and answers
+// the reserved __stats command (JSON encoded Snapshot).
+// Plug in another implementation with ServerMetrics(), __stats
+// works if it has Snapshot() too.
+
+const (
+    cmdStats = "__stats"
+
+    MetricConns         = "xsock_connections_total"
+    MetricConnsActive   = "xsock_connections_active"
+    MetricPacketsRx     = "xsock_packets_received_total"
+    MetricPacketsTx     = "xsock_packets_sent_total"
+    MetricCorrupt       = "xsock_packets_corrupt_total"
+    MetricTimeouts      = "xsock_timeouts_total"
+    MetricRejected      = "xsock_rejected_total"
+    MetricSessions      = "xsock_sessions_active"
+    MetricExpired       = "xsock_sessions_expired_total"
+    MetricLatency       = "xsock_handler_seconds"
+)
+
+var metricHelp = map[string]string{
+    MetricConns:        "Connections accepted.",
+    MetricConnsActive:  "Connections open.",
+    MetricPacketsRx:    "Packets received.",
+    MetricPacketsTx:    "Packets sent.",
+    MetricCorrupt:      "Packets failing header, version or fragment checks.",
+    MetricTimeouts:     "Reads and writes past their deadline.",
+    MetricRejected:     "Requests rejected by rate limits or session cap.",
+    MetricSessions:     "Sessions registered.",
+    MetricExpired:      "Sessions expired (TTL, idle, eviction).",
+    MetricLatency:      "Handler latency in seconds.",
+}
+
+// default histogram buckets, seconds
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+type Metrics interface {
+    Inc(name string, n int64)       // counter
+    Set(name string, v int64)       // gauge
+    Observe(name string, v float64) // histogram
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Inc(string, int64)       {}
+func (nopMetrics) Set(string, int64)       {}
+func (nopMetrics) Observe(string, float64) {}
+
+// Histogram counts are cumulative,
+// Counts[i] is observations <= Buckets[i]
+
+type Histogram struct {
+    Buckets []float64   `json:"buckets"`
+    Counts  []uint64    `json:"counts"`
+    Count   uint64      `json:"count"`
+    Sum     float64     `json:"sum"`
+}
+
+type Snapshot struct {
+    Counters    map[string]int64        `json:"counters"`
+    Gauges      map[string]int64        `json:"gauges"`
+    Histograms  map[string]Histogram    `json:"histograms"`
+}
+
+type Stats struct {
+    mu          sync.Mutex
+    counters    map[string]int64
+    gauges      map[string]int64
+    hists       map[string]*Histogram
+    buckets     []float64
+}
+
+// NewStats takes histogram bucket upper bounds (ascending),
+// latencyBuckets when none given
+
+func NewStats(buckets ...float64) *Stats {
+    if len(buckets) == 0 {
+        buckets = latencyBuckets
+    }
+
+    return &Stats{
+        counters:   make(map[string]int64),
+        gauges:     make(map[string]int64),
+        hists:      make(map[string]*Histogram),
+        buckets:    buckets,
+    }
+}
+
+func (st *Stats) Inc(name string, n int64) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+
+    st.counters[name] += n
+}
+
+func (st *Stats) Set(name string, v int64) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+
+    st.gauges[name] = v
+}
+
+func (st *Stats) Observe(name string, v float64) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+
+    h, ok := st.hists[name]
+    if !ok {
+        h = &Histogram{Buckets: st.buckets, Counts: make([]uint64, len(st.buckets))}
+        st.hists[name] = h
+    }
+
+    for i, b := range h.Buckets {
+        if v <= b {
+            h.Counts[i]++
+        }
+    }
+
+    h.Count++
+    h.Sum += v
+}
+
+func (st *Stats) Snapshot() Snapshot {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+
+    snap := Snapshot{
+        Counters:   make(map[string]int64, len(st.counters)),
+        Gauges:     make(map[string]int64, len(st.gauges)),
+        Histograms: make(map[string]Histogram, len(st.hists)),
+    }
+
+    for name, v := range st.counters {
+        snap.Counters[name] = v
+    }
+
+    for name, v := range st.gauges {
+        snap.Gauges[name] = v
+    }
+
+    for name, h := range st.hists {
+        c := *h
+        c.Counts = append([]uint64(nil), h.Counts...)
+        snap.Histograms[name] = c
+    }
+
+    return snap
+}
+
+// ServeHTTP is the Prometheus scrape endpoint,
+// eg. http.Handle("/metrics", stats)
+
+func (st *Stats) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    st.Snapshot().WritePrometheus(w)
+}
+
+// WritePrometheus writes snap in Prometheus text exposition format
+
+func (snap Snapshot) WritePrometheus(w io.Writer) error {
+    var err error
+
+    write := func(format string, a ...any) {
+        if err == nil {
+            _, err = fmt.Fprintf(w, format, a...)
+        }
+    }
+
+    header := func(name, kind string) {
+        if help, ok := metricHelp[name]; ok {
+            write("# HELP %s %s\n", name, help)
+        }
+        write("# TYPE %s %s\n", name, kind)
+    }
+
+    for _, name := range sortedKeys(snap.Counters) {
+        header(name, "counter")
+        write("%s %d\n", name, snap.Counters[name])
+    }
+
+    for _, name := range sortedKeys(snap.Gauges) {
+        header(name, "gauge")
+        write("%s %d\n", name, snap.Gauges[name])
+    }
+
+    for _, name := range sortedKeys(snap.Histograms) {
+        h := snap.Histograms[name]
+
+        header(name, "histogram")
+        for i, b := range h.Buckets {
+            write("%s_bucket{le=\"%s\"} %d\n", name, formatFloat(b), h.Counts[i])
+        }
+        write("%s_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+        write("%s_sum %s\n", name, formatFloat(h.Sum))
+        write("%s_count %d\n", name, h.Count)
+    }
+
+    if err != nil {
+        return v2.ErrCtx(err.Error(), "xmetrics")
+    }
+
+    return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+    k := make([]string, 0, len(m))
+    for name := range m {
+        k = append(k, name)
+    }
+    sort.Strings(k)
+
+    return k
+}
+
+func formatFloat(f float64) string {
+    if math.IsInf(f, 1) {
+        return "+Inf"
+    }
+
+    return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+
+//
+// Server side
+
+// Stats is a snapshot of server metrics,
+// false if Metrics cannot make one
+
+func (s *Server) Stats() (Snapshot, bool) {
+    m, ok := s.Metrics.(interface{ Snapshot() Snapshot })
+    if !ok {
+        return Snapshot{}, false
+    }
+
+    return m.Snapshot(), true
+}
+
+func (s *Server) stats() (string, bool) {
+    snap, ok := s.Stats()
+    if !ok {
+        return errStats.Ctx("xserver").Error(), false
+    }
+
+    b, err := json.Marshal(snap)
+    if err != nil {
+        return v2.ErrCtx(err.Error(), "xserver").Error(), false
+    }
+
+    return string(b), true
+}
+
+// packet counts packets going through a connection,
+// passes them on to Trace
+
+func (s *Server) packet(out bool, p *Packet) {
+    if out {
+        s.Metrics.Inc(MetricPacketsTx, 1)
+    } else {
+        s.Metrics.Inc(MetricPacketsRx, 1)
+    }
+
+    if s.Trace != nil {
+        s.Trace(out, p)
+    }
+}
+
+// failure counts err if it is a timeout or broken packet
+
+func (s *Server) failure(err error) {
+    switch ; {
+        case errors.Is(err, errTransmitTimeout):
+            s.Metrics.Inc(MetricTimeouts, 1)
+
+        case errors.Is(err, errCorrupted), errors.Is(err, errVersion), errors.Is(err, errLength),
+             errors.Is(err, errSequence), errors.Is(err, errFragment):
+            s.Metrics.Inc(MetricCorrupt, 1)
+    }
+}
+
+func (s *Server) sessionsGauge() {
+    s.Metrics.Set(MetricSessions, int64(s.Sessions.Len()))
+}
+
+
+//
+// Client side
+
+func (c *Client) Stats() (Snapshot, error) {
+    ctx, cancel := c.deadline()
+    defer cancel()
+
+    return c.StatsContext(ctx)
+}
+
+func (c *Client) StatsContext(ctx context.Context) (Snapshot, error) {
+    var snap Snapshot
+
+    msg, code, err := c.Exec(ctx, cmdStats)
+    if err != nil {
+        return snap, err
+    }
+
+    if code != ExitOK {
+        return snap, v2.ErrCtx(msg, "xclient")
+    }
+
+    if err := json.Unmarshal([]byte(msg), &snap); err != nil {
+        return snap, v2.ErrCtx(err.Error(), "xclient")
+    }
+
+    return snap, nil
+}
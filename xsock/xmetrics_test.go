@@ -0,0 +1,92 @@
+package xsock
+
+import (
+    "net/http/httptest"
+    "testing"
+)
+
+func TestPrometheus(t *testing.T) {
+    st := NewStats(1, 2)
+    st.Inc(MetricConns, 2)
+    st.Inc("other_total", 1)
+    st.Set(MetricSessions, 5)
+
+    for _, v := range []float64{0.5, 1.5, 3} {
+        st.Observe(MetricLatency, v)
+    }
+
+    // sorted by name within each kind, help only for known ones
+
+    want := `# TYPE other_total counter
+other_total 1
+# HELP xsock_connections_total Connections accepted.
+# TYPE xsock_connections_total counter
+xsock_connections_total 2
+# HELP xsock_sessions_active Sessions registered.
+# TYPE xsock_sessions_active gauge
+xsock_sessions_active 5
+# HELP xsock_handler_seconds Handler latency in seconds.
+# TYPE xsock_handler_seconds histogram
+xsock_handler_seconds_bucket{le="1"} 1
+xsock_handler_seconds_bucket{le="2"} 2
+xsock_handler_seconds_bucket{le="+Inf"} 3
+xsock_handler_seconds_sum 5
+xsock_handler_seconds_count 3
+`
+
+    rec := httptest.NewRecorder()
+    st.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+    if got := rec.Body.String(); got != want {
+        t.Fatalf("got\n%s\nwant\n%s", got, want)
+    }
+
+    if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+        t.Fatal(ct)
+    }
+}
+
+func TestStatsCommand(t *testing.T) {
+    _, path := serve(t, echo)
+
+    c, err := NewClient(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := c.Send("x"); err != nil {
+        t.Fatal(err)
+    }
+
+    snap, err := c.Stats()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // SYN, request, __stats on their own connections
+
+    if n := snap.Counters[MetricConns]; n != 3 {
+        t.Fatalf("%d connections", n)
+    }
+
+    if n := snap.Gauges[MetricSessions]; n != 1 {
+        t.Fatalf("%d sessions", n)
+    }
+
+    if h := snap.Histograms[MetricLatency]; h.Count != 1 {
+        t.Fatalf("%d handler calls", h.Count)
+    }
+}
+
+func TestStatsNop(t *testing.T) {
+    _, path := serve(t, echo, ServerMetrics(nopMetrics{}))
+
+    c, err := NewClient(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := c.Stats(); err == nil {
+        t.Fatal("stats from nop metrics")
+    }
+}
@@ -1,7 +1,8 @@
 package xsock
 
 import (
-    "math/rand"
+    "encoding/binary"
+    "fmt"
     v2 "vella/v2utils"
 )
 
@@ -18,11 +19,39 @@ const (
     syn uint8       = 0 // type syn
     ack uint8       = 1 // type ack
     cls uint8       = 2 // type close
-    scs uint8       = 0 // exit success
-    flt uint8       = 1 // exit fail
+    evt uint8       = 3 // type event (server push)
+
+    // frame
+    // ver(1)   - protocol version, must match on both ends
+    // seq(4)   - fragment sequence number, first is 0
+    // total(4) - length of the whole message across all fragments
+    // req(4)   - request id, reply carries the same (pipelining)
+    // plen(2)  - payload length of this packet
+    frameLen        = 15
+    frameOff        = idLen + sessLen
+    verOff          = frameOff
+    seqOff          = verOff + 1
+    totalOff        = seqOff + 4
+    reqOff          = totalOff + 4
+    plenOff         = reqOff + 4
+    version uint8   = 1
 
     // message
-    msgLen          = packetLen - idLen - sessLen
+    msgOff          = frameOff + frameLen
+    msgLen          = packetLen - msgOff
+
+    // whole (fragmented) message size
+    maxMsgStd       = 1024*1024     // default
+    maxMsgCap       = 64*1024*1024  // upper limit
+)
+
+// Exit codes of a reply, Packet.GetExit() and Client.Exec()
+
+const (
+    ExitOK       uint8 = 0 // success
+    ExitFail     uint8 = 1 // handler failed
+    ExitInvalid  uint8 = 2 // invalid or expired session
+    ExitRejected uint8 = 3 // rejected (rate limit, sessions full)
 )
 
 type pktMod func(*Packet)
@@ -33,6 +62,7 @@ type Packet struct {
 
 func NewPacket(pm ...pktMod) *Packet {
     p := &Packet{}
+    p.Stream[verOff] = version
 
     // SYN is default
     // ACK, CLS possible modifies (cls does not make much sense though..)
@@ -51,6 +81,7 @@ func NewPacket(pm ...pktMod) *Packet {
 func (p *Packet) IsSyn() bool { return p.Stream[idLen] == syn }
 func (p *Packet) IsAck() bool { return p.Stream[idLen] == ack }
 func (p *Packet) IsCls() bool { return p.Stream[idLen] == cls }
+func (p *Packet) IsEvt() bool { return p.Stream[idLen] == evt }
 
 func (p *Packet) IsCorrupt() (bool, error) {
     // total length - we care
@@ -63,7 +94,15 @@ func (p *Packet) IsCorrupt() (bool, error) {
         return true, errLength.Ctx("xpacket")
     }
 
-    if !p.IsSyn() && !p.IsAck() && !p.IsCls() {
+    if v := p.GetVersion(); v != version {
+        return true, errVersion.Ctx(fmt.Sprintf("xpacket(peer v%d, want v%d)", v, version))
+    }
+
+    if p.getPlen() > msgLen {
+        return true, errLength.Ctx("xpacket")
+    }
+
+    if !p.IsSyn() && !p.IsAck() && !p.IsCls() && !p.IsEvt() {
         return true, errType.Ctx("xpacket")
     }
 
@@ -108,20 +147,51 @@ func (p *Packet) GetSess() [sessLen]byte {
     return i
 }
 
-func (p *Packet) GetMsg() string {
-    // here we only expect ASCII chars,
-    // is this good enough..?
+func (p *Packet) GetExitCode() bool {
+    return p.GetExit() == ExitOK
+}
 
-    b := []byte{}
-    for c:=idLen+sessLen; c<packetLen; c++ {
-        if p.Stream[c] == 0 {
-            break
-        }
+func (p *Packet) GetExit() uint8 {
+    return p.Stream[idLen+sessLen-1]
+}
+
+func (p *Packet) GetVersion() uint8 {
+    return p.Stream[verOff]
+}
+
+func (p *Packet) GetSeq() uint32 {
+    return binary.BigEndian.Uint32(p.Stream[seqOff:totalOff])
+}
+
+func (p *Packet) GetTotal() uint32 {
+    return binary.BigEndian.Uint32(p.Stream[totalOff:reqOff])
+}
+
+func (p *Packet) GetReq() uint32 {
+    return binary.BigEndian.Uint32(p.Stream[reqOff:plenOff])
+}
+
+// Payload is this packet's part of the message only,
+// see rx() for the whole (fragmented) message
 
-        b = append(b, p.Stream[c])
+func (p *Packet) Payload() []byte {
+    n := p.getPlen()
+    if n > msgLen {
+        n = msgLen
     }
 
-    return string(b)    
+    b := make([]byte, n)
+    copy(b, p.Stream[msgOff:msgOff+n])
+
+    return b
+}
+
+func (p *Packet) GetMsg() string {
+    return string(p.Payload())
+}
+
+func (p *Packet) getPlen() int {
+    return int(binary.BigEndian.Uint16(p.Stream[plenOff:msgOff]))
 }
 
 
@@ -132,31 +202,57 @@ func (p *Packet) SetExitCode(b bool) {
     // true = success
     // false = fail
 
-    var e byte = flt
+    var e byte = ExitFail
     if b {
-        e = scs
+        e = ExitOK
     }
 
+    p.setExit(e)
+}
+
+func (p *Packet) setExit(e uint8) {
     p.Stream[idLen+sessLen-1] = e
 }
 
 func (p *Packet) ResetMsg(s string) {
-    for c:=idLen+sessLen; c<packetLen; c++ {
+    p.SetPayload([]byte(s))
+}
+
+func (p *Packet) SetMsg(s string) {
+    p.SetPayload([]byte(s))
+}
+
+// SetPayload replaces this packet's payload, single packet only,
+// anything past msgLen is dropped (see tx() for fragmenting).
+// Returns number of bytes used.
+
+func (p *Packet) SetPayload(b []byte) int {
+    n := copy(p.Stream[msgOff:], b)
+
+    for c:=msgOff+n; c<packetLen; c++ {
         p.Stream[c] = 0
     }
 
-    p.SetMsg(s)
+    binary.BigEndian.PutUint16(p.Stream[plenOff:msgOff], uint16(n))
+    return n
 }
 
-func (p *Packet) SetMsg(s string) {
-    for c, b := range s {
-        p.Stream[idLen+sessLen+c] = byte(b)
-    }
+func (p *Packet) SetSeq(i uint32) {
+    binary.BigEndian.PutUint32(p.Stream[seqOff:totalOff], i)
+}
+
+func (p *Packet) SetTotal(i uint32) {
+    binary.BigEndian.PutUint32(p.Stream[totalOff:reqOff], i)
+}
+
+func (p *Packet) SetReq(i uint32) {
+    binary.BigEndian.PutUint32(p.Stream[reqOff:plenOff], i)
 }
 
 func (p *Packet) SetSyn() { p.Stream[idLen] = syn }
 func (p *Packet) SetAck() { p.Stream[idLen] = ack }
 func (p *Packet) SetCls() { p.Stream[idLen] = cls }
+func (p *Packet) SetEvt() { p.Stream[idLen] = evt }
 
 func (p *Packet) SetId(id ...pktMod) []byte {
     var pim pktMod // pkt id modifier
@@ -165,14 +261,7 @@ func (p *Packet) SetId(id ...pktMod) []byte {
         case len(id) > 0:
             pim = id[0] // allow max one ID
         default:
-            // Id string generation => https://stackoverflow.com/questions/22892120/how-to-generate-a-random-string-of-a-fixed-length-in-go/22892986#22892986
-            // Id 3 - Remainder
-            var b [idLen]byte
-            for c:=0; c<idLen; c++ {
-                b[c] = idPool[rand.Int63() % int64(len(idPool))]
-            }
-
-            pim = setId(b)
+            pim = setId(newId()) // see xid.go
     }
 
     pim(p) // pimpin'..
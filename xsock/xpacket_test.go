@@ -0,0 +1,99 @@
+package xsock
+
+import (
+    "bytes"
+    "errors"
+    "net"
+    "strings"
+    "testing"
+)
+
+func TestPayloadBinary(t *testing.T) {
+    b := []byte{'a', 0, 0xff, 0xfe, 0, 0} // trailing zeros, not UTF-8
+
+    p := NewPacket()
+    if n := p.SetPayload(b); n != len(b) {
+        t.Fatalf("took %d of %d bytes", n, len(b))
+    }
+
+    if !bytes.Equal(p.Payload(), b) {
+        t.Fatalf("payload %v, want %v", p.Payload(), b)
+    }
+
+    // shorter payload clears the rest
+
+    p.SetPayload([]byte("x"))
+    if p.GetMsg() != "x" {
+        t.Fatalf("msg %q", p.GetMsg())
+    }
+}
+
+func TestPayloadCorrupt(t *testing.T) {
+    p := NewPacket(Ack(newId()))
+    p.SetPayload(make([]byte, msgLen))
+
+    if bad, err := p.IsCorrupt(); bad {
+        t.Fatal(err)
+    }
+
+    p.Stream[plenOff], p.Stream[plenOff+1] = 0xff, 0xff
+    if _, err := p.IsCorrupt(); !errors.Is(err, errLength) {
+        t.Fatal(err)
+    }
+
+    p = NewPacket(Ack(newId()))
+    p.Stream[verOff] = version + 1
+    if _, err := p.IsCorrupt(); !errors.Is(err, errVersion) {
+        t.Fatal(err)
+    }
+}
+
+// NULs, invalid UTF-8 and a rune split between two packets
+
+func TestBinaryMessage(t *testing.T) {
+    _, path := serve(t, echo)
+
+    c, err := NewClient(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    msg := strings.Repeat("a", msgLen-1) + "€" + "\x00\xff\x00" + strings.Repeat("\x00", msgLen)
+
+    got, err := c.Send(msg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if got != msg {
+        t.Fatalf("reply %d bytes, sent %d", len(got), len(msg))
+    }
+}
+
+// peer on another version is told so in ours
+
+func TestVersionMismatch(t *testing.T) {
+    _, path := serve(t, echo)
+
+    conn, err := net.Dial("unix", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+
+    p := NewPacket()
+    p.Stream[verOff] = version + 1
+
+    if _, err := conn.Write(p.Stream[:]); err != nil {
+        t.Fatal(err)
+    }
+
+    r, err := rxPacket(conn)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if r.GetVersion() != version || r.GetExit() != ExitFail || !strings.Contains(r.GetMsg(), "incompatible protocol version") {
+        t.Fatalf("v%d exit %d %q", r.GetVersion(), r.GetExit(), r.GetMsg())
+    }
+}
@@ -0,0 +1,165 @@
+package xsock
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "net"
+    "os/user"
+    "strconv"
+    "time"
+)
+
+// Peer is the other end of the connection
+//  Uid, Gid, Pid   - process credentials, unix socket only
+//  Cred            - false when credentials could not be read
+//                    (not a unix socket or not supported on this OS)
+//  Identity        - mapped from TLS client certificate (see CertIdentity())
+//  Addr            - remote address
+
+type Peer struct {
+    Uid         uint32
+    Gid         uint32
+    Pid         int32
+    Cred        bool
+    Identity    string
+    Addr        string
+}
+
+func (s *Server) peerOf(conn net.Conn) (Peer, error) {
+    var p Peer
+
+    switch c := conn.(type) {
+        case *net.UnixConn:
+            if cred, err := peerCred(c); err == nil {
+                p = cred
+            }
+
+        case *tls.Conn:
+            // handshake happens on first read/write otherwise,
+            // we need the certificate before that
+
+            c.SetDeadline(time.Now().Add(time.Duration(tlsWait) * time.Millisecond))
+            if err := c.Handshake(); err != nil {
+                return p, err
+            }
+            c.SetDeadline(time.Time{})
+
+            certs := c.ConnectionState().PeerCertificates
+            if len(certs) > 0 {
+                p.Identity = s.identity(certs[0])
+            }
+    }
+
+    if ra := conn.RemoteAddr(); ra != nil {
+        p.Addr = ra.String()
+    }
+
+    return p, nil
+}
+
+func commonName(cert *x509.Certificate) string {
+    return cert.Subject.CommonName
+}
+
+// authorized checks peer against allow lists,
+// no lists means everybody is allowed
+
+func (s *Server) authorized(p Peer) bool {
+    if len(s.allowUid) == 0 && len(s.allowGid) == 0 && len(s.allowId) == 0 {
+        return true
+    }
+
+    if p.Identity != "" && s.allowId[p.Identity] {
+        return true
+    }
+
+    if !p.Cred {
+        return false
+    }
+
+    if s.allowUid[p.Uid] || s.allowGid[p.Gid] {
+        return true
+    }
+
+    if len(s.allowGid) == 0 {
+        return false
+    }
+
+    // supplementary groups
+
+    u, err := user.LookupId(strconv.FormatUint(uint64(p.Uid), 10))
+    if err != nil {
+        return false
+    }
+
+    gids, err := u.GroupIds()
+    if err != nil {
+        return false
+    }
+
+    for _, g := range gids {
+        gid, err := strconv.ParseUint(g, 10, 32)
+        if err == nil && s.allowGid[uint32(gid)] {
+            return true
+        }
+    }
+
+    return false
+}
+
+
+//
+// Modifiers
+
+func AllowUid(uids ...uint32) serverModifier {
+    return func(s *Server) {
+        for _, uid := range uids {
+            s.allowUid[uid] = true
+        }
+    }
+}
+
+func AllowGid(gids ...uint32) serverModifier {
+    return func(s *Server) {
+        for _, gid := range gids {
+            s.allowGid[gid] = true
+        }
+    }
+}
+
+func AllowGroup(names ...string) serverModifier {
+    var gids []uint32
+
+    for _, name := range names {
+        g, err := user.LookupGroup(name)
+        if err != nil {
+            panic(errGroup.Ctx("xserver(" + name + ")"))
+        }
+
+        gid, err := strconv.ParseUint(g.Gid, 10, 32)
+        if err != nil {
+            panic(errGroup.Ctx("xserver(" + name + ")"))
+        }
+
+        gids = append(gids, uint32(gid))
+    }
+
+    return AllowGid(gids...)
+}
+
+func AllowIdentity(ids ...string) serverModifier {
+    return func(s *Server) {
+        for _, id := range ids {
+            s.allowId[id] = true
+        }
+    }
+}
+
+// CertIdentity maps TLS client certificate to Peer.Identity,
+// subject common name is used by default
+
+func CertIdentity(fn func(*x509.Certificate) string) serverModifier {
+    return func(s *Server) {
+        s.identity = fn
+    }
+}
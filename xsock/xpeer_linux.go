@@ -0,0 +1,30 @@
+//go:build linux
+
+package xsock
+
+import (
+    "net"
+    "syscall"
+)
+
+func peerCred(uc *net.UnixConn) (Peer, error) {
+    raw, err := uc.SyscallConn()
+    if err != nil {
+        return Peer{}, err
+    }
+
+    var cred *syscall.Ucred
+    var cerr error
+
+    err = raw.Control(func(fd uintptr) {
+        cred, cerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+    })
+    if err != nil {
+        return Peer{}, err
+    }
+    if cerr != nil {
+        return Peer{}, cerr
+    }
+
+    return Peer{Uid: cred.Uid, Gid: cred.Gid, Pid: cred.Pid, Cred: true}, nil
+}
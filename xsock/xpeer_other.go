@@ -0,0 +1,11 @@
+//go:build !linux
+
+package xsock
+
+import (
+    "net"
+)
+
+func peerCred(uc *net.UnixConn) (Peer, error) {
+    return Peer{}, errNoPeerCred.Ctx("xpeer")
+}
@@ -0,0 +1,82 @@
+package xsock
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// Request is what Server.ReqHandler gets,
+// the message plus what is known about the sender
+//  Session - session id
+//  Created - when the session was opened
+//  Count   - messages in the session, this one included
+//  Values  - per-session storage, lives as long as the session
+
+type Request struct {
+    Msg     string
+    Peer    Peer
+    Session string
+    Created time.Time
+    Count   int
+    Values  *Values
+
+    ctx     context.Context
+}
+
+func newRequest(msg string, peer Peer, sid string, sess session) *Request {
+    return &Request{
+        Msg:        msg,
+        Peer:       peer,
+        Session:    sid,
+        Created:    sess.time,
+        Count:      sess.count,
+        Values:     sess.values,
+        ctx:        sess.ctx,
+    }
+}
+
+// Context is cancelled when the session is closed or expires,
+// context.Cause() tells which
+
+func (r *Request) Context() context.Context {
+    if r.ctx == nil {
+        return context.Background()
+    }
+
+    return r.ctx
+}
+
+// Values is key/value store of a session,
+// safe for concurrent use (pipelined requests)
+
+type Values struct {
+    mu  sync.Mutex
+    m   map[string]any
+}
+
+func newValues() *Values {
+    return &Values{m: make(map[string]any)}
+}
+
+func (v *Values) Get(key string) (any, bool) {
+    v.mu.Lock()
+    defer v.mu.Unlock()
+
+    val, ok := v.m[key]
+    return val, ok
+}
+
+func (v *Values) Set(key string, val any) {
+    v.mu.Lock()
+    defer v.mu.Unlock()
+
+    v.m[key] = val
+}
+
+func (v *Values) Delete(key string) {
+    v.mu.Lock()
+    defer v.mu.Unlock()
+
+    delete(v.m, key)
+}
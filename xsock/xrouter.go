@@ -0,0 +1,265 @@
+package xsock
+
+import (
+    "context"
+    "encoding/json"
+    "reflect"
+    "sort"
+    "strings"
+    "sync"
+    v2 "vella/v2utils"
+)
+
+// Router dispatches JSON encoded calls to named commands,
+// plug it in with Server.RegisterReqHandler(router.Handle)
+//  call:   {"cmd": "name", "args": <args>}
+//  reply:  {"result": <result>, "error": "..."}
+
+const (
+    cmdHelp = "help"
+    cmdList = "list"
+)
+
+type rCall struct {
+    Cmd     string          `json:"cmd"`
+    Args    json.RawMessage `json:"args,omitempty"`
+}
+
+type rReply struct {
+    Result  json.RawMessage `json:"result,omitempty"`
+    Error   string          `json:"error,omitempty"`
+}
+
+// CommandInfo is what help returns per command
+
+type CommandInfo struct {
+    Name    string  `json:"name"`
+    Desc    string  `json:"desc"`
+    Args    string  `json:"args"`
+    Result  string  `json:"result"`
+}
+
+type command struct {
+    info    CommandInfo
+    fn      func(*Request, json.RawMessage) (any, error)
+}
+
+type Router struct {
+    mu      sync.RWMutex
+    cmds    map[string]*command
+}
+
+func NewRouter() *Router {
+    r := &Router{cmds: make(map[string]*command)}
+
+    Command(r, cmdList, "list registered commands", func(_ *Request, _ struct{}) ([]string, error) {
+        return r.names(), nil
+    })
+
+    Command(r, cmdHelp, "describe all commands or the one named in args", func(_ *Request, name string) ([]CommandInfo, error) {
+        return r.help(name)
+    })
+
+    return r
+}
+
+// Command registers fn as name, args are decoded into A
+// and the result R encoded back as JSON
+
+func Command[A any, R any](r *Router, name, desc string, fn func(*Request, A) (R, error)) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if name == "" {
+        panic(errCommand.Ctx("xrouter"))
+    }
+
+    if _, ok := r.cmds[name]; ok {
+        panic(errDuplicateCmd.Ctx("xrouter(" + name + ")"))
+    }
+
+    info := CommandInfo{
+        Name:   name,
+        Desc:   desc,
+        Args:   describe(reflect.TypeOf((*A)(nil)).Elem()),
+        Result: describe(reflect.TypeOf((*R)(nil)).Elem()),
+    }
+
+    r.cmds[name] = &command{info, func(req *Request, raw json.RawMessage) (any, error) {
+        var args A
+
+        if len(raw) > 0 {
+            if err := json.Unmarshal(raw, &args); err != nil {
+                return nil, errArgs.Ctx("xrouter(" + name + ")")
+            }
+        }
+
+        return fn(req, args)
+    }}
+}
+
+func (r *Router) Handle(req *Request) (string, bool) {
+    var c rCall
+
+    if err := json.Unmarshal([]byte(req.Msg), &c); err != nil {
+        return r.reply(nil, errCall.Ctx("xrouter"))
+    }
+
+    r.mu.RLock()
+    cmd, ok := r.cmds[c.Cmd]
+    r.mu.RUnlock()
+
+    if !ok {
+        return r.reply(nil, errCommand.Ctx("xrouter(" + c.Cmd + ")"))
+    }
+
+    return r.reply(cmd.fn(req, c.Args))
+}
+
+func (r *Router) reply(result any, err error) (string, bool) {
+    var rep rReply
+
+    if err != nil {
+        rep.Error = err.Error()
+    } else if b, e := json.Marshal(result); e != nil {
+        rep.Error = v2.ErrCtx(e.Error(), "xrouter").Error()
+    } else {
+        rep.Result = b
+    }
+
+    b, _ := json.Marshal(rep)
+    return string(b), rep.Error == ""
+}
+
+func (r *Router) names() []string {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    n := make([]string, 0, len(r.cmds))
+    for name := range r.cmds {
+        n = append(n, name)
+    }
+    sort.Strings(n)
+
+    return n
+}
+
+func (r *Router) help(name string) ([]CommandInfo, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    if name != "" {
+        cmd, ok := r.cmds[name]
+        if !ok {
+            return nil, errCommand.Ctx("xrouter(" + name + ")")
+        }
+
+        return []CommandInfo{cmd.info}, nil
+    }
+
+    h := make([]CommandInfo, 0, len(r.cmds))
+    for _, cmd := range r.cmds {
+        h = append(h, cmd.info)
+    }
+    sort.Slice(h, func(i, j int) bool { return h[i].Name < h[j].Name })
+
+    return h, nil
+}
+
+// describe is a short, JSON-ish, description of t
+// eg. {name string, count int}
+
+func describe(t reflect.Type) string {
+    switch t.Kind() {
+        case reflect.Pointer:
+            return describe(t.Elem())
+
+        case reflect.Slice, reflect.Array:
+            return "[]" + describe(t.Elem())
+
+        case reflect.Map:
+            return "map[" + describe(t.Key()) + "]" + describe(t.Elem())
+
+        case reflect.Struct:
+            var f []string
+            for i:=0; i<t.NumField(); i++ {
+                sf := t.Field(i)
+                if !sf.IsExported() {
+                    continue
+                }
+
+                name := sf.Name
+                if tag := strings.Split(sf.Tag.Get("json"), ",")[0]; tag == "-" {
+                    continue
+                } else if tag != "" {
+                    name = tag
+                }
+
+                f = append(f, name + " " + describe(sf.Type))
+            }
+
+            return "{" + strings.Join(f, ", ") + "}"
+
+        case reflect.Interface:
+            return "any"
+    }
+
+    return t.Kind().String()
+}
+
+
+//
+// Client side
+
+func (c *Client) Call(cmd string, args any, reply any) error {
+    ctx, cancel := c.deadline()
+    defer cancel()
+
+    return c.CallContext(ctx, cmd, args, reply)
+}
+
+// CallContext runs cmd on the server Router, reply (pointer)
+// receives the decoded result and may be nil if not wanted
+
+func (c *Client) CallContext(ctx context.Context, cmd string, args any, reply any) error {
+    call := rCall{Cmd: cmd}
+
+    if args != nil {
+        b, err := json.Marshal(args)
+        if err != nil {
+            return v2.ErrCtx(err.Error(), "xclient")
+        }
+
+        call.Args = b
+    }
+
+    b, err := json.Marshal(call)
+    if err != nil {
+        return v2.ErrCtx(err.Error(), "xclient")
+    }
+
+    msg, err := c.SendContext(ctx, string(b))
+    if err != nil {
+        return err
+    }
+
+    // anything that is not a router reply is
+    // an error from the server itself (eg. expired id)
+
+    var rep rReply
+    if err := json.Unmarshal([]byte(msg), &rep); err != nil {
+        return v2.ErrCtx(msg, "xclient")
+    }
+
+    if rep.Error != "" {
+        return v2.ErrCtx(rep.Error, "xclient")
+    }
+
+    if reply != nil && len(rep.Result) > 0 {
+        if err := json.Unmarshal(rep.Result, reply); err != nil {
+            return v2.ErrCtx(err.Error(), "xclient")
+        }
+    }
+
+    return nil
+}
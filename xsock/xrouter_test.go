@@ -0,0 +1,104 @@
+package xsock
+
+import (
+    "context"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+type addArgs struct {
+    A       int `json:"a"`
+    B       int `json:"b"`
+    Secret  int `json:"-"`
+}
+
+func routed(t *testing.T) *Client {
+    t.Helper()
+
+    r := NewRouter()
+    Command(r, "add", "a plus b", func(_ *Request, args addArgs) (int, error) {
+        return args.A + args.B, nil
+    })
+
+    _, path := serve(t, echo, func(s *Server) { s.ReqHandler = r.Handle })
+
+    c, err := NewClient(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return c
+}
+
+func TestRouterCall(t *testing.T) {
+    c := routed(t)
+
+    var sum int
+    if err := c.Call("add", addArgs{A: 2, B: 3}, &sum); err != nil || sum != 5 {
+        t.Fatal(sum, err)
+    }
+
+    // no args is the zero value
+
+    if err := c.Call("add", nil, &sum); err != nil || sum != 0 {
+        t.Fatal(sum, err)
+    }
+}
+
+func TestRouterErrors(t *testing.T) {
+    c := routed(t)
+
+    if err := c.Call("nope", nil, nil); err == nil || !strings.Contains(err.Error(), "unknown command") {
+        t.Fatal(err)
+    }
+
+    if err := c.Call("add", "not an object", nil); err == nil || !strings.Contains(err.Error(), "invalid arguments") {
+        t.Fatal(err)
+    }
+
+    // not JSON at all, server tells and exit code is failure
+
+    msg, code, err := c.Exec(context.Background(), "add 2 3")
+    if err != nil || code != ExitFail || !strings.Contains(msg, "invalid call") {
+        t.Fatal(msg, code, err)
+    }
+}
+
+func TestRouterListHelp(t *testing.T) {
+    c := routed(t)
+
+    var names []string
+    if err := c.Call(cmdList, nil, &names); err != nil {
+        t.Fatal(err)
+    }
+
+    if want := []string{"add", cmdHelp, cmdList}; !reflect.DeepEqual(names, want) {
+        t.Fatalf("list %q, want %q", names, want)
+    }
+
+    var info []CommandInfo
+    if err := c.Call(cmdHelp, "add", &info); err != nil {
+        t.Fatal(err)
+    }
+
+    want := CommandInfo{Name: "add", Desc: "a plus b", Args: "{a int, b int}", Result: "int"}
+    if len(info) != 1 || info[0] != want {
+        t.Fatalf("help %+v, want %+v", info, want)
+    }
+
+    if err := c.Call(cmdHelp, nil, &info); err != nil || len(info) != 3 {
+        t.Fatal(info, err)
+    }
+}
+
+func TestRouterDuplicate(t *testing.T) {
+    defer func() {
+        if recover() == nil {
+            t.Fatal("duplicate command registered")
+        }
+    }()
+
+    r := NewRouter()
+    Command(r, cmdList, "", func(*Request, struct{}) (int, error) { return 0, nil })
+}
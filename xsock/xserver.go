@@ -1,59 +1,219 @@
 package xsock
 
 import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
     "net"
     "os"
+    "os/signal"
     "io"
+    "strings"
+    "sync"
+    "syscall"
     "time"
     v2 "vella/v2utils"
 )
 
 const (
     sessTTL = 600 // 10mins
+    workers = 16  // requests handled at a time
+    clsWait = 250 // milliseconds, CLS write on Shutdown()
+    tlsWait = 5000 // milliseconds, TLS handshake
+    evtWait = 1000 // milliseconds, event write
+    readWait  = 60000 // milliseconds, request read (idle connection too)
+    writeWait = 10000 // milliseconds, reply write
 )
 
-type session struct {
-    time    time.Time
-    count   int
-}
-
-type sessions map[string]*session
-
 type serverModifier func(*Server)
 
 type Server struct {
-    Path        string
+    Path        string // address, see parseAddr()
     Listener    net.Listener
+    TLS         *tls.Config
     Force       bool
-    Sessions    sessions
-    Ttl         int
+    Sessions    *sessions
+    Ttl         int // absolute, since session created
+    Idle        int // since session last used, 0 is off
+    MaxSessions int  // 0 is unlimited, least recently used is evicted
+    RejectFull  bool // reject new sessions over MaxSessions instead of evicting
+    MaxMsg      int
+    Workers     int // requests handled at a time, see Serve()
+    EventQueue  int   // events waiting per subscriber
+    SlowPolicy  uint8 // SlowDrop, SlowClose
     Handler     func(string)(string, bool) // func(question)(answer, exit)
+    ReqHandler  func(*Request)(string, bool) // takes precedence over Handler
+    OnError     func(error) // errors from Serve() connection handlers
+    Trace       func(bool, *Packet) // every packet sent (true) and received (false)
+    Metrics     Metrics // see xmetrics.go, Stats by default
+
+    // session callbacks, called with session id
+    // expire covers reaper, expiry on request and max sessions eviction
+
+    OnSessionOpen   func(string)
+    OnSessionExpire func(string)
+    OnSessionClose  func(string)
+
+    // connection deadlines, 0 is off
+    // read is per request, covers idle persistent connection too
+    // (but not one with subscriptions, that waits for events).
+    // Idle one is closed quietly (CLS, session stays), only
+    // a stall in the middle of a request counts as timeout.
+
+    ReadTimeout     time.Duration
+    WriteTimeout    time.Duration
+
+    addr        addr
+    events      *pubsub
+    clientCAs   *x509.CertPool // mutual TLS
+    identity    func(*x509.Certificate) string
+    key         []byte // signed session ids, see xid.go
+    synLimit    *limiter // per peer rate limits, see xlimit.go
+    msgLimit    *limiter
+    workers     chan struct{} // Workers slots
+
+    allowUid    map[uint32]bool
+    allowGid    map[uint32]bool
+    allowId     map[string]bool
+
+    mu          sync.Mutex
+    conns       map[*sconn]struct{}
+    wg          sync.WaitGroup
+    done        chan struct{}
+    doneOnce    sync.Once
 }
 
 func NewServer(path string, mods ...serverModifier) (*Server, error) {
-    s := &Server{Path: path, Sessions: make(sessions), Ttl: sessTTL}
+    s := &Server{Path: path, Sessions: newSessions(), Ttl: sessTTL, MaxMsg: maxMsgStd, Workers: workers, EventQueue: evtQueue}
+    s.conns = make(map[*sconn]struct{})
+    s.allowUid = make(map[uint32]bool)
+    s.allowGid = make(map[uint32]bool)
+    s.allowId = make(map[string]bool)
+    s.identity = commonName
+    s.events = newPubsub()
+    s.Metrics = NewStats()
+    s.done = make(chan struct{})
+    s.ReadTimeout = time.Duration(readWait) * time.Millisecond
+    s.WriteTimeout = time.Duration(writeWait) * time.Millisecond
 
     for _, m := range mods {
         m(s)
     }
 
-    if fi, err := os.Stat(path); err == nil {
-        if s.Force && (fi.Mode() & os.ModeSocket) != 0 {
-            os.Remove(path) // stale socket
+    s.workers = make(chan struct{}, s.Workers)
+
+    if s.key != nil {
+        s.Sessions.ended = make(map[string]time.Time) // see resume()
+    }
+
+    // listener supplied by caller (see Listener()),
+    // nothing to set up
+
+    if s.Listener != nil {
+        go s.reaper()
+        return s, nil
+    }
+
+    a, err := parseAddr(path)
+    if err != nil {
+        return nil, err
+    }
+    s.addr = a
+
+    if sock := a.sock(); sock != "" {
+        if fi, err := os.Stat(sock); err == nil {
+            if (fi.Mode() & os.ModeSocket) != 0 && (s.Force || staleSocket(sock)) {
+                os.Remove(sock)
+            }
         }
     }
 
-    l, err := net.Listen("unix", path)
+    conf := s.TLS
+    if conf != nil && s.clientCAs != nil {
+        conf = conf.Clone()
+        conf.ClientCAs = s.clientCAs
+        conf.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+
+    l, err := a.listen(conf)
     if err != nil {
         return nil, v2.ErrCtx(err.Error(), "xserver")
     }
 
     s.Listener = l
+    go s.reaper()
+
     return s, nil
 }
 
+// Serve accepts connections until ctx is done (or Accept() fails)
+// and hands each to its own goroutine. At most s.Workers requests
+// are handled at a time, the rest wait (read, unanswered) for a free
+// worker. Connections waiting for their next request or for events
+// do not hold one, idle ones are closed after ReadTimeout.
+// Waits for the running handlers before returning. Ctx done is
+// Shutdown() with no time limit, idle connections are closed,
+// in-flight requests finish first.
+
+func (s *Server) Serve(ctx context.Context) error {
+    if s.Handler == nil && s.ReqHandler == nil {
+        panic(errMissingHandler.Ctx("xserver"))
+    }
+
+    done := make(chan struct{})
+    defer close(done)
+
+    go func() {
+        select {
+            case <- ctx.Done():
+                s.Listener.Close() // unblock Accept()
+            case <- done:
+        }
+    }()
+
+    defer s.wg.Wait()
+
+    // handlers waiting on idle connections
+    // would never finish otherwise
+
+    defer func() {
+        if ctx.Err() == nil {
+            return
+        }
+
+        if _, err := s.Shutdown(context.Background()); err != nil && s.OnError != nil {
+            s.OnError(err)
+        }
+    }()
+
+    for {
+        conn, err := s.Listener.Accept()
+        if err != nil {
+            if ctx.Err() != nil || s.closed() {
+                return nil
+            }
+
+            return v2.ErrCtx(err.Error(), "xserver")
+        }
+
+        if !s.begin() {
+            conn.Close()
+            return nil
+        }
+
+        go func() {
+            defer s.wg.Done()
+
+            if err := s.handle(conn); err != nil && s.OnError != nil {
+                s.OnError(err)
+            }
+        }()
+    }
+}
+
 func (s *Server) AcceptAndHandle() error {
-    if s.Handler == nil {
+    if s.Handler == nil && s.ReqHandler == nil {
         panic(errMissingHandler.Ctx("xserver"))
     }
 
@@ -61,15 +221,123 @@ func (s *Server) AcceptAndHandle() error {
     if err != nil {
         return v2.ErrCtx(err.Error(), "xserver")
     }
+
+    if !s.begin() {
+        conn.Close()
+        return nil
+    }
+    defer s.wg.Done()
+
+    return s.handle(conn)
+}
+
+// sconn is the server side of an open connection,
+// writes are serialized so multi-packet messages don't interleave
+
+type sconn struct {
+    net.Conn
+    wmu     sync.Mutex
+    id      [idLen]byte // last session seen, under Server.mu
+    busy    bool        // request in progress, under Server.mu
+}
+
+// send writes p with msg, within wait if not 0
+
+func (c *sconn) send(p *Packet, msg []byte, max int, wait time.Duration) error {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+
+    if wait > 0 {
+        c.SetWriteDeadline(time.Now().Add(wait))
+        defer c.SetWriteDeadline(time.Time{})
+    }
+
+    return tx(c.Conn, p, msg, max)
+}
+
+// handle serves requests on conn until the client hangs up,
+// one-shot clients send a single request, persistent ones many
+
+func (s *Server) handle(conn net.Conn) error {
     defer conn.Close()
 
-    p, err := rx(conn)
+    s.Metrics.Inc(MetricConns, 1)
+
+    peer, err := s.peerOf(conn)
     if err != nil {
-        if err == io.EOF { // close()
+        return v2.ErrCtx(err.Error(), "xserver")
+    }
+
+    conn = traced(conn, s.packet)
+
+    sc := s.track(conn)
+    defer s.untrack(sc)
+    defer s.events.dropConn(sc)
+
+    for {
+        switch ; {
+            case s.ReadTimeout > 0 && !s.events.subscribed(sc):
+                conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+            default:
+                conn.SetReadDeadline(time.Time{})
+        }
+
+        p, msg, err := rx(conn, s.MaxMsg)
+        if err != nil {
+            if err == io.EOF || s.closed() { // close() or Shutdown()
+                return nil
+            }
+
+            // nothing came within ReadTimeout, not an error,
+            // session stays for the client's next connection
+
+            if errors.Is(err, errIdle) {
+                s.closeConn(sc, false)
+                return nil
+            }
+
+            s.failure(err)
+
+            // tell the peer why, in our protocol version,
+            // the stream is out of sync anyway so hang up after
+
+            if p != nil && (errors.Is(err, errMsgTooLarge) || errors.Is(err, errVersion)) {
+                p.Stream[verOff] = version
+                p.SetExitCode(false)
+                s.reply(sc, p, []byte(err.Error()))
+            }
+
+            return v2.ErrCtx(err.Error(), "xserver")
+        }
+
+        s.setBusy(sc, true)
+        s.workers <- struct{}{}
+        err = s.request(sc, peer, p, msg)
+        <- s.workers
+        s.setBusy(sc, false)
+
+        if err != nil {
+            s.failure(err)
+            return err
+        }
+
+        if s.closed() {
+            s.closeConn(sc, true)
             return nil
         }
+    }
+}
 
-        return v2.ErrCtx(err.Error(), "xserver")
+func (s *Server) request(sc *sconn, peer Peer, p *Packet, msg []byte) error {
+    var response string
+
+    if !s.authorized(peer) {
+        if p.IsCls() {
+            return nil
+        }
+
+        p.SetExitCode(false)
+        return s.reply(sc, p, []byte(errDenied.Ctx("xserver").Error()))
     }
 
     switch ; {
@@ -78,87 +346,498 @@ func (s *Server) AcceptAndHandle() error {
         // generate session ID, update packet+session with ID, set to ACK and return
 
         case p.IsSyn():
-            s.RegisterSession(p.SetId())
+            if !s.synLimit.allow(peerKey(peer)) {
+                return s.reject(sc, p, errRateLimit)
+            }
+
+            id := s.newId()
+            if !s.registerSession(string(id[:]), time.Now()) {
+                return s.reject(sc, p, errSessionsFull)
+            }
+
+            p.SetId(setId(id))
+            s.setSession(sc, id)
             p.SetAck()
 
         // CLS
         case p.IsCls():
             s.UnregisterSession(p.GetId())
+            s.setSession(sc, [idLen]byte{})
             return nil
 
         // ACK
         // any other receives are ACK and expect answer
 
         default:
-            var response string
             var exitcode bool
 
-            err = s.Sessions.update(p.GetIdStr(), s.Ttl)
+            if !s.msgLimit.allow(peerKey(peer)) {
+                return s.reject(sc, p, errRateLimit)
+            }
+
+            s.setSession(sc, p.GetId())
+
+            sess, err := s.Sessions.update(p.GetIdStr(), s.Ttl, s.Idle)
+            if errors.Is(err, errInvalidId) && s.resume(p.GetId()) {
+                sess, err = s.Sessions.update(p.GetIdStr(), s.Ttl, s.Idle)
+            }
+
             if err != nil {
-                response, exitcode = err.Error(), false
+                if errors.Is(err, errExpiredId) {
+                    s.expired(p.GetIdStr())
+                }
+
+                p.setExit(ExitInvalid)
+                return s.reply(sc, p, []byte(err.Error()))
+            }
+
+            if r, ok, builtin := s.builtin(sc, p.GetId(), string(msg)); builtin {
+                response, exitcode = r, ok
             } else {
-                response, exitcode = s.Handler(p.GetMsg())
+                start := time.Now()
+                response, exitcode = s.call(newRequest(string(msg), peer, p.GetIdStr(), sess))
+                s.Metrics.Observe(MetricLatency, time.Since(start).Seconds())
+            }
+
+            if len(response) > s.MaxMsg {
+                response, exitcode = errMsgTooLarge.Ctx("xserver").Error(), false
             }
 
-            p.ResetMsg(response)
             p.SetExitCode(exitcode)
     }
 
-    return tx(conn, p)
+    return s.reply(sc, p, []byte(response))
 }
 
-func (s *Server) UnregisterSession(id [idLen]byte) sessions {
-    sid := string(id[:])
+func (s *Server) reply(sc *sconn, p *Packet, msg []byte) error {
+    return sc.send(p, msg, s.MaxMsg, s.WriteTimeout)
+}
+
+// reject tells the peer it went over a limit (rate, sessions)
+
+func (s *Server) reject(sc *sconn, p *Packet, err *v2.Error) error {
+    s.Metrics.Inc(MetricRejected, 1)
+
+    p.setExit(ExitRejected)
+    return s.reply(sc, p, []byte(err.Ctx("xserver").Error()))
+}
+
+// Shutdown stops accepting, closes idle connections and waits for in-flight
+// handlers until ctx is done. Connections still open at that point are closed
+// too. Every connection closed by Shutdown gets a CLS for its session first.
+// Returns the number of sessions dropped (still registered) and ctx error if
+// the handlers did not finish in time (errors.Is() matches ctx.Err()).
+// Socket file is removed.
+
+func (s *Server) Shutdown(ctx context.Context) (int, error) {
+    s.mu.Lock()
+    s.doneOnce.Do(func() { close(s.done) })
+    s.mu.Unlock()
+
+    err := s.Listener.Close()
+    if err != nil && !errors.Is(err, net.ErrClosed) {
+        return 0, v2.ErrCtx(err.Error(), "xserver")
+    }
+
+    s.closeConns(true)
+
+    drained := make(chan struct{})
+    go func() {
+        s.wg.Wait()
+        close(drained)
+    }()
+
+    select {
+        case <- drained:
+        case <- ctx.Done():
+            err = ctx.Err()
+            s.closeConns(false)
+    }
+
+    dropped := s.Sessions.clear()
+    s.sessionsGauge()
+
+    if sock := s.addr.sock(); sock != "" {
+        if fi, e := os.Stat(sock); e == nil && (fi.Mode() & os.ModeSocket) != 0 {
+            os.Remove(sock)
+        }
+    }
+
+    if err != nil {
+        return dropped, wrapCtx(v2.ErrCtx(err.Error(), "xserver"), err)
+    }
+
+    return dropped, nil
+}
+
+// Close is Shutdown() without waiting for in-flight handlers
+
+func (s *Server) Close() error {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    _, err := s.Shutdown(ctx)
+    if err != nil && !errors.Is(err, context.Canceled) {
+        return err
+    }
+
+    return nil
+}
+
+// ShutdownOnSignal runs Shutdown() on first of sigs (SIGTERM, SIGINT when none given)
+// allowing timeout for in-flight handlers. Number of dropped sessions is sent
+// on the returned channel, which is closed without value if the server is shut
+// down some other way.
+
+func (s *Server) ShutdownOnSignal(timeout time.Duration, sigs ...os.Signal) <-chan int {
+    if len(sigs) == 0 {
+        sigs = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+    }
+
+    ch := make(chan os.Signal, 1)
+    signal.Notify(ch, sigs...)
+
+    dropped := make(chan int, 1)
+    go func() {
+        defer close(dropped)
+        defer signal.Stop(ch)
+
+        select {
+            case <- ch:
+                ctx, cancel := context.WithTimeout(context.Background(), timeout)
+                defer cancel()
+
+                n, err := s.Shutdown(ctx)
+                if err != nil && s.OnError != nil {
+                    s.OnError(err)
+                }
+
+                dropped <- n
+            case <- s.done:
+        }
+    }()
+
+    return dropped
+}
+
+func (s *Server) closed() bool {
+    select {
+        case <- s.done:
+            return true
+        default:
+            return false
+    }
+}
+
+// begin registers an in-flight handler, unless shutting down
+
+func (s *Server) begin() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.closed() {
+        return false
+    }
+
+    s.wg.Add(1)
+    return true
+}
+
+func (s *Server) track(conn net.Conn) *sconn {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sc := &sconn{Conn: conn}
+    s.conns[sc] = struct{}{}
+    s.Metrics.Set(MetricConnsActive, int64(len(s.conns)))
+
+    return sc
+}
+
+func (s *Server) untrack(sc *sconn) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    delete(s.conns, sc)
+    s.Metrics.Set(MetricConnsActive, int64(len(s.conns)))
+}
+
+func (s *Server) setBusy(sc *sconn, b bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sc.busy = b
+}
+
+func (s *Server) setSession(sc *sconn, id [idLen]byte) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sc.id = id
+}
+
+// closeConns closes tracked connections, idle ones only
+// or all, sending CLS where there is a session (ended,
+// Shutdown() clears them). No I/O under s.mu, CLS writes
+// run side by side.
+
+func (s *Server) closeConns(idle bool) {
+    var wg sync.WaitGroup
+
+    for sc, id := range s.untrackAll(idle) {
+        wg.Add(1)
+        go func(sc *sconn, id [idLen]byte) {
+            defer wg.Done()
+            s.cls(sc, id, true)
+        }(sc, id)
+    }
+
+    wg.Wait()
+}
+
+func (s *Server) closeConn(sc *sconn, ended bool) {
+    s.mu.Lock()
+    id := sc.id
+    delete(s.conns, sc)
+    s.mu.Unlock()
+
+    s.cls(sc, id, ended)
+}
+
+// untrackAll removes connections (idle ones only or all),
+// returns them with their last session
+
+func (s *Server) untrackAll(idle bool) map[*sconn][idLen]byte {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    r := make(map[*sconn][idLen]byte)
+    for sc := range s.conns {
+        if idle && sc.busy {
+            continue
+        }
+
+        r[sc] = sc.id
+        delete(s.conns, sc)
+    }
+
+    return r
+}
+
+// cls closes sc, CLS exit code tells the client if session
+// id ended with it (ExitInvalid) or is still good (ExitOK)
 
-    r := make(sessions)
-    r[sid] = s.Sessions[sid]
+func (s *Server) cls(sc *sconn, id [idLen]byte, ended bool) {
+    // best effort, client may not be listening,
+    // writer stuck on the connection gets it closed
+    // under its hands instead
 
-    delete(s.Sessions, sid)
+    if id[0] != 0 && sc.wmu.TryLock() {
+        p := NewPacket(Cls(id))
+        if ended {
+            p.setExit(ExitInvalid)
+        }
+
+        sc.SetWriteDeadline(time.Now().Add(time.Duration(clsWait) * time.Millisecond))
+        tx(sc.Conn, p, nil, s.MaxMsg)
+        sc.wmu.Unlock()
+    }
+
+    sc.Close()
+}
+
+func (s *Server) UnregisterSession(id [idLen]byte) *session {
+    sid := string(id[:])
+
+    r := s.Sessions.unregister(sid, errSessionEnd.Ctx("xserver"))
+    if r != nil {
+        s.endSession(s.OnSessionClose, sid)
+    }
 
     return r
 }
 
 func (s *Server) RegisterSession(id []byte) {
-    s.Sessions[string(id)] = &session{time: time.Now(), count: 0}
+    s.registerSession(string(id), time.Now())
 }
 
-func (s *Server) RegisterHandler(fn func(string)(string, bool)) {
-    s.Handler = fn
+// registerSession is false when sessions are full
+// and RejectFull is set
+
+func (s *Server) registerSession(sid string, created time.Time) bool {
+    lru, evicted, ok := s.Sessions.register(sid, created, s.MaxSessions, !s.RejectFull)
+    if evicted {
+        s.expired(lru)
+    }
+
+    if !ok {
+        return false
+    }
+
+    s.sessionsGauge()
+    s.sessionEvent(s.OnSessionOpen, sid)
+
+    return true
 }
 
-func (s sessions) update(sid string, ttl int) error {
-    if _, ok := s[sid]; !ok {
-        return errInvalidId.Ctx("xserver")
+// ExpireSession ends session sid as if its TTL ran out
+
+func (s *Server) ExpireSession(sid string) bool {
+    if s.Sessions.unregister(sid, errExpiredId.Ctx("xserver")) == nil {
+        return false
     }
 
-    var err error
-    var del []string
+    s.expired(sid)
+    return true
+}
 
-    now := time.Now()
-    for id, val := range s {
-        if now.Sub(val.time) > time.Duration(ttl) * time.Second {
-            if sid == id {
-                err = errExpiredId.Ctx("xserver")
-            }
+func (s *Server) newId() [idLen]byte {
+    if s.key == nil {
+        return newId()
+    }
 
-            del = append(del, id)
-        }
+    return signedId(s.key, time.Now())
+}
+
+// resume re-registers session id issued by this server
+// (same key) before restart, if it is still within TTL.
+// Last use is not known, id older than Idle is not resumed
+// either. Sessions that ended here (closed, expired, evicted)
+// stay ended.
+
+func (s *Server) resume(id [idLen]byte) bool {
+    if s.key == nil {
+        return false
     }
 
-    if err == nil && len(del) > 0 {
-        panic(v2.ErrCtx("How did I get here??", "xserver"))
+    created, ok := verifyId(s.key, id)
+    if !ok {
+        return false
     }
 
-    if err != nil {
-        for _, id := range del {
-            delete(s, id)
+    age := time.Since(created)
+    switch ; {
+        case age > time.Duration(s.Ttl) * time.Second:
+            return false
+        case s.Idle > 0 && age > time.Duration(s.Idle) * time.Second:
+            return false
+        case s.Sessions.Ended(string(id[:])):
+            return false
+    }
+
+    return s.registerSession(string(id[:]), created)
+}
+
+// reaper expires sessions in the background
+// until the server is shut down
+
+func (s *Server) reaper() {
+    t := time.NewTicker(s.reapEvery())
+    defer t.Stop()
+
+    for {
+        select {
+            case <- t.C:
+                for _, sid := range s.Sessions.expire(s.Ttl, s.Idle) {
+                    s.expired(sid)
+                }
+
+                s.synLimit.prune()
+                s.msgLimit.prune()
+            case <- s.done:
+                return
         }
+    }
+}
 
-        return err
+func (s *Server) reapEvery() time.Duration {
+    // tenth of the shortest TTL,
+    // no more often than once a second
+
+    ttl := s.Ttl
+    if s.Idle > 0 && s.Idle < ttl {
+        ttl = s.Idle
     }
 
-    s[sid].count++
-    return nil
+    d := time.Duration(ttl) * time.Second / 10
+    if d < time.Second {
+        d = time.Second
+    }
+
+    return d
+}
+
+// builtin runs reserved (__ prefixed) commands,
+// false when msg is not one of them
+
+func (s *Server) builtin(sc *sconn, id [idLen]byte, msg string) (string, bool, bool) {
+    switch ; {
+        case strings.HasPrefix(msg, cmdSub):
+            if err := s.subscribe(sc, id, strings.TrimPrefix(msg, cmdSub)); err != nil {
+                return err.Error(), false, true
+            }
+
+            return "", true, true
+
+        case strings.HasPrefix(msg, cmdUnsub):
+            s.unsubscribe(sc, strings.TrimPrefix(msg, cmdUnsub))
+            return "", true, true
+
+        case msg == cmdStats:
+            r, ok := s.stats()
+            return r, ok, true
+    }
+
+    return "", false, false
+}
+
+// endSession cleans up after closed/expired session
+// and lets the owner know
+
+func (s *Server) endSession(fn func(string), sid string) {
+    s.events.dropSession(sid)
+    s.sessionsGauge()
+    s.sessionEvent(fn, sid)
+}
+
+func (s *Server) expired(sid string) {
+    s.Metrics.Inc(MetricExpired, 1)
+    s.endSession(s.OnSessionExpire, sid)
+}
+
+func (s *Server) sessionEvent(fn func(string), sid string) {
+    if fn != nil {
+        fn(sid)
+    }
+}
+
+func (s *Server) RegisterHandler(fn func(string)(string, bool)) {
+    s.Handler = fn
+}
+
+func (s *Server) RegisterReqHandler(fn func(*Request)(string, bool)) {
+    s.ReqHandler = fn
+}
+
+func (s *Server) call(r *Request) (string, bool) {
+    if s.ReqHandler != nil {
+        return s.ReqHandler(r)
+    }
+
+    return s.Handler(r.Msg)
+}
+
+
+func staleSocket(path string) bool {
+    // nobody listening on the other end
+
+    conn, err := net.Dial("unix", path)
+    if err != nil {
+        return errors.Is(err, syscall.ECONNREFUSED)
+    }
+
+    conn.Close()
+    return false
 }
 
 
@@ -171,6 +850,105 @@ func Force(b bool) serverModifier {
     }
 }
 
+// Listener makes the server use l instead of listening on path
+// (eg. in-memory listener, socket activation)
+
+func Listener(l net.Listener) serverModifier {
+    return func(s *Server) {
+        s.Listener = l
+    }
+}
+
+func ServerTrace(fn func(bool, *Packet)) serverModifier {
+    return func(s *Server) {
+        s.Trace = fn
+    }
+}
+
+// ServerMetrics replaces default Stats, nil turns metrics off
+
+func ServerMetrics(m Metrics) serverModifier {
+    if m == nil {
+        m = nopMetrics{}
+    }
+
+    return func(s *Server) {
+        s.Metrics = m
+    }
+}
+
+func TLSConfig(conf *tls.Config) serverModifier {
+    return func(s *Server) {
+        s.TLS = conf
+    }
+}
+
+// MutualTLS requires and verifies client certificates against pool
+
+func MutualTLS(pool *x509.CertPool) serverModifier {
+    return func(s *Server) {
+        s.clientCAs = pool
+    }
+}
+
+func MaxMsg(max int) serverModifier {
+    if max < 1 || max > maxMsgCap {
+        panic(errMaxMsg.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.MaxMsg = max
+    }
+}
+
+func Workers(n int) serverModifier {
+    if n < 1 {
+        panic(errWorkers.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.Workers = n
+    }
+}
+
+func ErrorHandler(fn func(error)) serverModifier {
+    return func(s *Server) {
+        s.OnError = fn
+    }
+}
+
+func EventQueue(n int) serverModifier {
+    if n < 1 {
+        panic(errEventQueue.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.EventQueue = n
+    }
+}
+
+// SlowSubscriber is what happens to subscriber
+// whose event queue is full (SlowDrop, SlowClose)
+
+func SlowSubscriber(policy uint8) serverModifier {
+    return func(s *Server) {
+        s.SlowPolicy = policy
+    }
+}
+
+// SessionKey makes session ids HMAC signed tokens,
+// server restarted with the same key resumes them
+
+func SessionKey(key []byte) serverModifier {
+    if len(key) == 0 {
+        panic(errSessionKey.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.key = key
+    }
+}
+
 func SessTTL(ttl int) serverModifier {
     if ttl > 3600 || ttl < 1 {
         panic(errTTL.Ctx("xserver"))
@@ -180,3 +958,93 @@ func SessTTL(ttl int) serverModifier {
         s.Ttl = ttl
     }
 }
+
+func IdleTTL(ttl int) serverModifier {
+    if ttl > 3600 || ttl < 0 {
+        panic(errTTL.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.Idle = ttl
+    }
+}
+
+// RejectFull makes new sessions over MaxSessions fail
+// (exit code ExitRejected) instead of evicting the oldest one
+
+func RejectFull(b bool) serverModifier {
+    return func(s *Server) {
+        s.RejectFull = b
+    }
+}
+
+// SynRate and MsgRate limit new sessions and messages per peer
+// to rate a second, allowing bursts of up to burst
+
+func SynRate(rate float64, burst int) serverModifier {
+    if rate <= 0 || burst < 1 {
+        panic(errRate.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.synLimit = newLimiter(rate, burst)
+    }
+}
+
+func MsgRate(rate float64, burst int) serverModifier {
+    if rate <= 0 || burst < 1 {
+        panic(errRate.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.msgLimit = newLimiter(rate, burst)
+    }
+}
+
+func ReadTimeout(d time.Duration) serverModifier {
+    if d < 0 {
+        panic(errTimeout.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.ReadTimeout = d
+    }
+}
+
+func WriteTimeout(d time.Duration) serverModifier {
+    if d < 0 {
+        panic(errTimeout.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.WriteTimeout = d
+    }
+}
+
+func MaxSessions(n int) serverModifier {
+    if n < 0 {
+        panic(errMaxSessions.Ctx("xserver"))
+    }
+
+    return func(s *Server) {
+        s.MaxSessions = n
+    }
+}
+
+func OnSessionOpen(fn func(string)) serverModifier {
+    return func(s *Server) {
+        s.OnSessionOpen = fn
+    }
+}
+
+func OnSessionExpire(fn func(string)) serverModifier {
+    return func(s *Server) {
+        s.OnSessionExpire = fn
+    }
+}
+
+func OnSessionClose(fn func(string)) serverModifier {
+    return func(s *Server) {
+        s.OnSessionClose = fn
+    }
+}
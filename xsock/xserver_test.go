@@ -0,0 +1,164 @@
+package xsock
+
+import (
+    "context"
+    "errors"
+    "net"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// serve starts server on a unix socket in a temp dir with handler fn,
+// returns it and its address. Shut down at the end of the test.
+
+func serve(t *testing.T, fn func(string) (string, bool), mods ...serverModifier) (*Server, string) {
+    t.Helper()
+
+    path := filepath.Join(t.TempDir(), "x.sock")
+
+    s, err := NewServer(path, mods...)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    s.RegisterHandler(fn)
+
+    served := make(chan error, 1)
+    go func() {
+        served <- s.Serve(context.Background())
+    }()
+
+    t.Cleanup(func() {
+        s.Close()
+        <- served
+    })
+
+    return s, path
+}
+
+func echo(msg string) (string, bool) {
+    return msg, true
+}
+
+func TestCloseIdle(t *testing.T) {
+    s, path := serve(t, echo)
+
+    c, err := NewClient(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := c.Send("x"); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := s.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestShutdownDeadline(t *testing.T) {
+    release := make(chan struct{})
+    defer close(release)
+
+    s, path := serve(t, func(msg string) (string, bool) {
+        <- release
+        return msg, true
+    })
+
+    c, err := NewClient(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    go c.Send("x")
+    time.Sleep(50 * time.Millisecond)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+    defer cancel()
+
+    if _, err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatal(err)
+    }
+}
+
+// idle connection is closed quietly, session stays
+
+func TestIdleClose(t *testing.T) {
+    errs := make(chan error, 1)
+    s, path := serve(t, echo, ReadTimeout(100 * time.Millisecond), ErrorHandler(func(err error) { errs <- err }))
+
+    c, err := NewClient(path, Persistent(true))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    sid := c.Session
+    time.Sleep(300 * time.Millisecond)
+
+    select {
+        case err := <- errs:
+            t.Fatal(err)
+        default:
+    }
+
+    if snap, _ := s.Stats(); snap.Counters[MetricTimeouts] != 0 {
+        t.Fatalf("%d timeouts", snap.Counters[MetricTimeouts])
+    }
+
+    if _, code, err := c.Exec(context.Background(), "x"); err != nil || code != ExitOK || c.Session != sid {
+        t.Fatalf("exit %d, %v", code, err)
+    }
+}
+
+// stall in the middle of a request is a timeout
+
+func TestStallTimeout(t *testing.T) {
+    errs := make(chan error, 1)
+    s, path := serve(t, echo, ReadTimeout(100 * time.Millisecond), ErrorHandler(func(err error) { errs <- err }))
+
+    conn, err := net.Dial("unix", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+
+    p := NewPacket()
+    conn.Write(p.Stream[:packetLen/2])
+
+    select {
+        case err := <- errs:
+            if !strings.Contains(err.Error(), "transmission timeout") {
+                t.Fatal(err)
+            }
+        case <- time.After(time.Second):
+            t.Fatal("no timeout")
+    }
+
+    if snap, _ := s.Stats(); snap.Counters[MetricTimeouts] != 1 {
+        t.Fatalf("%d timeouts", snap.Counters[MetricTimeouts])
+    }
+}
+
+// CLS on Shutdown ends the client's session too
+
+func TestShutdownCls(t *testing.T) {
+    s, path := serve(t, echo)
+
+    c, err := NewClient(path, Persistent(true))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if err := s.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    for start := time.Now(); c.ActiveSession(); time.Sleep(time.Millisecond) {
+        if time.Since(start) > time.Second {
+            t.Fatal("session kept after Shutdown()")
+        }
+    }
+}
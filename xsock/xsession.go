@@ -0,0 +1,194 @@
+package xsock
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+type session struct {
+    time    time.Time // created
+    last    time.Time // last used
+    count   int
+    values  *Values
+    ctx     context.Context // cancelled when session ends
+    cancel  context.CancelCauseFunc
+}
+
+func newSession(created time.Time) *session {
+    ctx, cancel := context.WithCancelCause(context.Background())
+    return &session{time: created, last: time.Now(), values: newValues(), ctx: ctx, cancel: cancel}
+}
+
+func (s *session) expired(now time.Time, ttl, idle int) bool {
+    // ttl  - absolute, since created
+    // idle - since last used, 0 is off
+
+    if now.Sub(s.time) > time.Duration(ttl) * time.Second {
+        return true
+    }
+
+    if idle > 0 && now.Sub(s.last) > time.Duration(idle) * time.Second {
+        return true
+    }
+
+    return false
+}
+
+// sessions is shared by all connection handlers,
+// every access goes through the lock.
+// With signed ids ended sessions are remembered (with their
+// creation time) until past TTL, those are not resumed.
+
+type sessions struct {
+    sync.Mutex
+    m       map[string]*session
+    ended   map[string]time.Time // nil unless ids are signed
+}
+
+func newSessions() *sessions {
+    return &sessions{m: make(map[string]*session)}
+}
+
+// end removes session sid, lock held
+
+func (s *sessions) end(sid string, cause error) {
+    val := s.m[sid]
+    val.cancel(cause)
+    if s.ended != nil {
+        s.ended[sid] = val.time
+    }
+    delete(s.m, sid)
+}
+
+// Ended is true if session sid was here and ended
+// (closed, expired, evicted) within TTL
+
+func (s *sessions) Ended(sid string) bool {
+    s.Lock()
+    defer s.Unlock()
+
+    _, ok := s.ended[sid]
+    return ok
+}
+
+func (s *sessions) Len() int {
+    s.Lock()
+    defer s.Unlock()
+
+    return len(s.m)
+}
+
+// register adds new session, if that goes over max (0 is unlimited)
+// least recently used session is evicted and its id returned,
+// or, with evict false, the new one is not added (ok false)
+
+func (s *sessions) register(sid string, created time.Time, max int, evict bool) (string, bool, bool) {
+    s.Lock()
+    defer s.Unlock()
+
+    var lru string
+    var evicted bool
+
+    _, exists := s.m[sid]
+    if max > 0 && len(s.m) >= max && !exists {
+        if !evict {
+            return "", false, false
+        }
+
+        var oldest time.Time
+        for id, val := range s.m {
+            if !evicted || val.last.Before(oldest) {
+                lru, oldest, evicted = id, val.last, true
+            }
+        }
+
+        s.end(lru, errExpiredId.Ctx("xserver"))
+    }
+
+    if old, ok := s.m[sid]; ok {
+        old.cancel(errSessionEnd.Ctx("xserver")) // re-registered (resumed) id
+    }
+
+    s.m[sid] = newSession(created)
+
+    return lru, evicted, true
+}
+
+// unregister removes session, its context
+// is cancelled with cause
+
+func (s *sessions) unregister(sid string, cause error) *session {
+    s.Lock()
+    defer s.Unlock()
+
+    r := s.m[sid]
+    if r != nil {
+        s.end(sid, cause)
+    }
+
+    return r
+}
+
+func (s *sessions) clear() int {
+    s.Lock()
+    defer s.Unlock()
+
+    n := len(s.m)
+    for sid := range s.m {
+        s.end(sid, errSessionEnd.Ctx("xserver"))
+    }
+
+    return n
+}
+
+// expire removes all sessions past ttl/idle,
+// returns their ids. Forgets ended ones past ttl.
+
+func (s *sessions) expire(ttl, idle int) []string {
+    s.Lock()
+    defer s.Unlock()
+
+    var del []string
+
+    now := time.Now()
+    for id, val := range s.m {
+        if val.expired(now, ttl, idle) {
+            s.end(id, errExpiredId.Ctx("xserver"))
+            del = append(del, id)
+        }
+    }
+
+    for id, created := range s.ended {
+        if now.Sub(created) > time.Duration(ttl) * time.Second {
+            delete(s.ended, id)
+        }
+    }
+
+    return del
+}
+
+// update checks caller's session only,
+// others are left for the reaper.
+// Returns copy of the session as it is after update.
+
+func (s *sessions) update(sid string, ttl, idle int) (session, error) {
+    s.Lock()
+    defer s.Unlock()
+
+    val, ok := s.m[sid]
+    if !ok {
+        return session{}, errInvalidId.Ctx("xserver")
+    }
+
+    now := time.Now()
+    if val.expired(now, ttl, idle) {
+        s.end(sid, errExpiredId.Ctx("xserver"))
+        return session{}, errExpiredId.Ctx("xserver")
+    }
+
+    val.count++
+    val.last = now
+
+    return *val, nil
+}
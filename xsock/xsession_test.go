@@ -0,0 +1,60 @@
+package xsock
+
+import (
+    "testing"
+    "time"
+)
+
+func TestEndedSigned(t *testing.T) {
+    for _, signed := range []bool{false, true} {
+        var mods []serverModifier
+        if signed {
+            mods = append(mods, SessionKey([]byte("key")))
+        }
+
+        s, path := serve(t, echo, mods...)
+
+        c, err := NewClient(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        sid := string(c.Session[:])
+        if !s.ExpireSession(sid) {
+            t.Fatal("no session")
+        }
+
+        // unsigned ids are never resumed,
+        // nothing to remember them for
+
+        if s.Sessions.Ended(sid) != signed {
+            t.Fatalf("signed %v: ended %v", signed, !signed)
+        }
+    }
+}
+
+func TestResumeAge(t *testing.T) {
+    key := []byte("key")
+
+    s, _ := serve(t, echo, SessionKey(key), SessTTL(60), IdleTTL(10))
+
+    for _, c := range []struct {
+        age     time.Duration
+        resume  bool
+    }{
+        {time.Second, true},
+        {20 * time.Second, false}, // past Idle, last use unknown
+        {2 * time.Minute, false},  // past TTL
+    } {
+        id := signedId(key, time.Now().Add(-c.age))
+        if s.resume(id) != c.resume {
+            t.Fatalf("%v old id resumed %v", c.age, !c.resume)
+        }
+    }
+
+    // other key
+
+    if s.resume(signedId([]byte("other"), time.Now())) {
+        t.Fatal("id signed with other key resumed")
+    }
+}
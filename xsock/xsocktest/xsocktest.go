@@ -0,0 +1,350 @@
+package xsocktest
+
+import (
+    "context"
+    "io"
+    "net"
+    "sync"
+    "sync/atomic"
+    "time"
+    v2 "vella/v2utils"
+    "vella/v2utils/xsock"
+)
+
+// Harness runs xsock Server and Client connected over in-memory
+// pipes (net.Pipe), no socket file needed. Every packet going through
+// either end is recorded in the transcript.
+//
+//  h, err := xsocktest.New(handler, xsocktest.WithServer(xsock.MaxMsg(1024)))
+//  defer h.Close()
+//  msg, err := h.Client.Send("ping")
+
+const (
+    SideServer = "server"
+    SideClient = "client"
+
+    closeWait = 1000 // milliseconds, Close() waits for in-flight handlers
+)
+
+var (
+    errListenerClosed = v2.Err("Listener closed")
+)
+
+type Option func(*config)
+
+type config struct {
+    server  []func(*xsock.Server)
+    client  []func(*xsock.Client)
+}
+
+// WithServer/WithClient pass xsock modifiers through,
+// eg. WithServer(xsock.SessTTL(1), xsock.IdleTTL(1))
+
+func WithServer(mods ...func(*xsock.Server)) Option {
+    return func(c *config) {
+        c.server = append(c.server, mods...)
+    }
+}
+
+func WithClient(mods ...func(*xsock.Client)) Option {
+    return func(c *config) {
+        c.client = append(c.client, mods...)
+    }
+}
+
+// Record is one packet of the transcript
+//  Side    - SideServer or SideClient
+//  Out     - true sent, false received
+
+type Record struct {
+    Side    string
+    Out     bool
+    Packet  *xsock.Packet
+    Time    time.Time
+}
+
+type Harness struct {
+    Server  *xsock.Server
+    Client  *xsock.Client
+
+    l       *pipeListener
+    cancel  context.CancelFunc
+    served  chan error
+
+    mu      sync.Mutex
+    records []Record
+}
+
+func New(handler func(*xsock.Request) (string, bool), opts ...Option) (*Harness, error) {
+    var conf config
+    for _, o := range opts {
+        o(&conf)
+    }
+
+    h := &Harness{l: newPipeListener(), served: make(chan error, 1)}
+
+    s, err := xsock.NewServer("", xsock.Listener(h.l), xsock.ServerTrace(h.trace(SideServer)), func(s *xsock.Server) {
+        for _, m := range conf.server {
+            m(s)
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    s.RegisterReqHandler(handler)
+    h.Server = s
+
+    var ctx context.Context
+    ctx, h.cancel = context.WithCancel(context.Background())
+
+    go func() {
+        h.served <- s.Serve(ctx)
+    }()
+
+    c, err := xsock.NewClient("", xsock.Dialer(h.l.Dial), xsock.ClientTrace(h.trace(SideClient)), func(c *xsock.Client) {
+        for _, m := range conf.client {
+            m(c)
+        }
+    })
+    if err != nil {
+        h.shutdown()
+        return nil, err
+    }
+
+    h.Client = c
+
+    return h, nil
+}
+
+// Close closes the client session and shuts the server down,
+// returns the first error of the two
+
+func (h *Harness) Close() error {
+    err := h.Client.Close()
+
+    if e := h.shutdown(); err == nil {
+        err = e
+    }
+
+    return err
+}
+
+func (h *Harness) shutdown() error {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Duration(closeWait) * time.Millisecond)
+    defer cancel()
+
+    _, err := h.Server.Shutdown(ctx)
+    h.cancel()
+
+    if e := <- h.served; err == nil {
+        err = e
+    }
+
+    return err
+}
+
+func (h *Harness) trace(side string) func(bool, *xsock.Packet) {
+    return func(out bool, p *xsock.Packet) {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+
+        h.records = append(h.records, Record{Side: side, Out: out, Packet: p, Time: time.Now()})
+    }
+}
+
+// Transcript returns packets recorded so far, in order
+// each end saw them (server and client interleaved). Sent packets
+// are recorded once the write returns, reply may show up on the
+// client side first.
+
+func (h *Harness) Transcript() []Record {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    r := make([]Record, len(h.records))
+    copy(r, h.records)
+
+    return r
+}
+
+// Reset clears the transcript
+
+func (h *Harness) Reset() {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    h.records = nil
+}
+
+// Session is id of the client's current session
+
+func (h *Harness) Session() string {
+    return string(h.Client.Session[:])
+}
+
+// Expire ends session sid on the server as if its TTL ran out,
+// false if there is no such session
+
+func (h *Harness) Expire(sid string) bool {
+    return h.Server.ExpireSession(sid)
+}
+
+// Stall delays every server write by d (0 turns it off),
+// client calls with shorter deadline time out
+
+func (h *Harness) Stall(d time.Duration) {
+    h.l.stall.Store(int64(d))
+}
+
+
+//
+// Raw packets
+
+// Raw is a new connection to the server which bypasses
+// the client, for writing packets by hand
+
+func (h *Harness) Raw() (net.Conn, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), h.Client.Timeout)
+    defer cancel()
+
+    return h.l.Dial(ctx)
+}
+
+// Packet is ACK on the client's session carrying msg (single packet,
+// msg is cut to what fits), ready to be changed and Inject()ed
+
+func (h *Harness) Packet(msg string) *xsock.Packet {
+    p := xsock.NewPacket()
+    copy(p.Stream[:], h.Client.Session[:])
+    p.SetAck()
+
+    n := p.SetPayload([]byte(msg))
+    p.SetTotal(uint32(n))
+
+    return p
+}
+
+// Inject writes b on a new raw connection and returns the
+// first packet of the reply. Server hanging up without reply
+// gives io.EOF, no reply within client's Timeout an error
+// (eg. b is shorter than a packet and server waits for the rest).
+
+func (h *Harness) Inject(b []byte) (*xsock.Packet, error) {
+    conn, err := h.Raw()
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+
+    conn.SetDeadline(time.Now().Add(h.Client.Timeout))
+
+    // pipe writes block until read, server may never
+    // read all of b, conn.Close() ends the write
+
+    go conn.Write(b)
+
+    return ReadPacket(conn)
+}
+
+func (h *Harness) InjectPacket(p *xsock.Packet) (*xsock.Packet, error) {
+    return h.Inject(p.Stream[:])
+}
+
+// ReadPacket reads single packet off conn, as is (no checks)
+
+func ReadPacket(conn net.Conn) (*xsock.Packet, error) {
+    p := &xsock.Packet{}
+
+    _, err := io.ReadFull(conn, p.Stream[:])
+    if err != nil {
+        if err == io.ErrUnexpectedEOF {
+            err = io.EOF
+        }
+
+        return nil, err
+    }
+
+    return p, nil
+}
+
+
+//
+// Listener
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeListener hands server end of every Dial()ed pipe to Accept()
+
+type pipeListener struct {
+    conns   chan net.Conn
+    done    chan struct{}
+    once    sync.Once
+    stall   atomic.Int64
+}
+
+func newPipeListener() *pipeListener {
+    return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+    select {
+        case c := <- l.conns:
+            return c, nil
+        case <- l.done:
+            return nil, net.ErrClosed
+    }
+}
+
+func (l *pipeListener) Close() error {
+    l.once.Do(func() { close(l.done) })
+    return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+    return pipeAddr{}
+}
+
+func (l *pipeListener) Dial(ctx context.Context) (net.Conn, error) {
+    cli, srv := net.Pipe()
+
+    var err error
+
+    select {
+        case l.conns <- &stallConn{Conn: srv, l: l}:
+            return cli, nil
+        case <- ctx.Done():
+            err = v2.ErrCtx(ctx.Err().Error(), "xsocktest")
+        case <- l.done:
+            err = errListenerClosed.Ctx("xsocktest")
+    }
+
+    cli.Close()
+    srv.Close()
+
+    return nil, err
+}
+
+// stallConn is server end of the pipe, see Stall()
+
+type stallConn struct {
+    net.Conn
+    l       *pipeListener
+}
+
+func (c *stallConn) Write(b []byte) (int, error) {
+    if d := time.Duration(c.l.stall.Load()); d > 0 {
+        t := time.NewTimer(d)
+
+        select {
+            case <- t.C:
+            case <- c.l.done:
+                t.Stop()
+        }
+    }
+
+    return c.Conn.Write(b)
+}
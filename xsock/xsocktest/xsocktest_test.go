@@ -0,0 +1,300 @@
+package xsocktest
+
+import (
+    "context"
+    "errors"
+    "io"
+    "os"
+    "strings"
+    "testing"
+    "time"
+    "vella/v2utils/xsock"
+)
+
+func echo(r *xsock.Request) (string, bool) {
+    return r.Msg, true
+}
+
+func newHarness(t *testing.T, opts ...Option) *Harness {
+    t.Helper()
+
+    h, err := New(echo, opts...)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return h
+}
+
+// records waits for n transcript records, server's are
+// recorded after the client may have its reply already
+
+func records(t *testing.T, h *Harness, n int) []Record {
+    t.Helper()
+
+    for start := time.Now(); ; time.Sleep(time.Millisecond) {
+        r := h.Transcript()
+        if len(r) >= n {
+            return r
+        }
+
+        if time.Since(start) > time.Second {
+            t.Fatalf("%d records, want %d", len(r), n)
+        }
+    }
+}
+
+func TestTranscript(t *testing.T) {
+    h := newHarness(t)
+    defer h.Close()
+
+    records(t, h, 4) // SYN
+    h.Reset()
+
+    if _, err := h.Client.Send("hi"); err != nil {
+        t.Fatal(err)
+    }
+
+    // one request, one reply, seen from both ends
+
+    seen := make(map[string]int)
+    for _, r := range records(t, h, 4) {
+        if r.Packet.GetMsg() != "hi" || !r.Packet.IsAck() {
+            t.Fatalf("%s out %v: %q", r.Side, r.Out, r.Packet.GetMsg())
+        }
+
+        dir := " in"
+        if r.Out {
+            dir = " out"
+        }
+
+        seen[r.Side + dir]++
+    }
+
+    for _, k := range []string{SideClient + " out", SideServer + " in", SideServer + " out", SideClient + " in"} {
+        if seen[k] != 1 {
+            t.Fatalf("%v", seen)
+        }
+    }
+}
+
+func TestInject(t *testing.T) {
+    h := newHarness(t, WithClient(xsock.ClientTimeout(200 * time.Millisecond)))
+    defer h.Close()
+
+    r, err := h.InjectPacket(h.Packet("raw"))
+    if err != nil || r.GetMsg() != "raw" || r.GetExit() != xsock.ExitOK {
+        t.Fatal(r, err)
+    }
+
+    // invalid id, server hangs up
+
+    p := h.Packet("raw")
+    p.Stream[0] = '!'
+    if _, err := h.InjectPacket(p); err != io.EOF {
+        t.Fatal(err)
+    }
+
+    // less than a packet, server waits for the rest
+
+    if _, err := h.Inject([]byte("abc")); !errors.Is(err, os.ErrDeadlineExceeded) {
+        t.Fatal(err)
+    }
+}
+
+func TestStall(t *testing.T) {
+    h := newHarness(t)
+    defer h.Close()
+
+    h.Stall(300 * time.Millisecond)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+    defer cancel()
+
+    if _, err := h.Client.SendContext(ctx, "x"); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatal(err)
+    }
+
+    h.Stall(0)
+
+    if _, err := h.Client.Send("x"); err != nil {
+        t.Fatal(err)
+    }
+
+    if h.Expire("nope") {
+        t.Fatal("expired unknown session")
+    }
+}
+
+func TestFragmentation(t *testing.T) {
+    h := newHarness(t)
+    defer h.Close()
+
+    msg := strings.Repeat("0123456789", 10000)
+
+    h.Reset()
+    got, err := h.Client.Send(msg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if got != msg {
+        t.Fatalf("reply %d bytes, sent %d", len(got), len(msg))
+    }
+
+    // client side of the request, fragments in order
+
+    var seq uint32
+    for _, r := range h.Transcript() {
+        if r.Side != SideClient || !r.Out {
+            continue
+        }
+
+        if r.Packet.GetSeq() != seq || r.Packet.GetTotal() != uint32(len(msg)) {
+            t.Fatalf("fragment %d: seq %d total %d", seq, r.Packet.GetSeq(), r.Packet.GetTotal())
+        }
+        seq++
+    }
+
+    if seq < 2 {
+        t.Fatalf("sent in %d packets", seq)
+    }
+}
+
+func TestExpire(t *testing.T) {
+    h := newHarness(t)
+    defer h.Close()
+
+    if !h.Expire(h.Session()) {
+        t.Fatal("no session to expire")
+    }
+
+    _, code, err := h.Client.Exec(context.Background(), "x")
+    if err != nil || code != xsock.ExitInvalid {
+        t.Fatalf("exit %d, %v", code, err)
+    }
+}
+
+func TestExpireResyn(t *testing.T) {
+    h := newHarness(t, WithClient(xsock.Resyn(true)))
+    defer h.Close()
+
+    sid := h.Session()
+    h.Expire(sid)
+
+    got, code, err := h.Client.Exec(context.Background(), "x")
+    if err != nil || code != xsock.ExitOK || got != "x" {
+        t.Fatalf("%q exit %d, %v", got, code, err)
+    }
+
+    if h.Session() == sid {
+        t.Fatal("still on expired session")
+    }
+}
+
+func TestResume(t *testing.T) {
+    key := xsock.SessionKey([]byte("key"))
+
+    h1 := newHarness(t, WithServer(key))
+    defer h1.Close()
+
+    h2 := newHarness(t, WithServer(key))
+    defer h2.Close()
+
+    // h1's session on h2, as if the server restarted
+
+    h2.Client.Session = h1.Client.Session
+
+    got, code, err := h2.Client.Exec(context.Background(), "x")
+    if err != nil || code != xsock.ExitOK || got != "x" {
+        t.Fatalf("%q exit %d, %v", got, code, err)
+    }
+
+    // h2's own plus the resumed one
+
+    if h2.Server.Sessions.Len() != 2 {
+        t.Fatal("session not resumed")
+    }
+}
+
+func TestNoResumeClosed(t *testing.T) {
+    h := newHarness(t, WithServer(xsock.SessionKey([]byte("key"))))
+    defer h.Close()
+
+    sid := h.Client.Session
+    if err := h.Client.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    // CLS has no reply, wait for the server to take it
+
+    for start := time.Now(); !h.Server.Sessions.Ended(string(sid[:])); {
+        if time.Since(start) > time.Second {
+            t.Fatal("CLS not handled")
+        }
+
+        time.Sleep(time.Millisecond)
+    }
+
+    h.Client.Session = sid
+
+    _, code, err := h.Client.Exec(context.Background(), "x")
+    if err != nil || code != xsock.ExitInvalid {
+        t.Fatalf("exit %d, %v", code, err)
+    }
+
+    if h.Server.Sessions.Len() != 0 {
+        t.Fatal("closed session resumed")
+    }
+}
+
+func TestServeCancelPersistent(t *testing.T) {
+    h := newHarness(t, WithClient(xsock.Persistent(true)))
+
+    if _, err := h.Client.Send("x"); err != nil {
+        t.Fatal(err)
+    }
+
+    // idle persistent connection must not hold Serve()
+
+    h.cancel()
+
+    select {
+        case <- h.served:
+        case <- time.After(2 * time.Second):
+            t.Fatal("Serve() blocked by persistent connection")
+    }
+}
+
+func TestShutdownStuckWriter(t *testing.T) {
+    big := strings.Repeat("x", 16*1024*1024)
+
+    h, err := New(func(*xsock.Request) (string, bool) {
+        return big, true
+    }, WithServer(xsock.MaxMsg(32*1024*1024)))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // request the big reply and never read it
+
+    conn, err := h.Raw()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+
+    go conn.Write(h.Packet("big").Stream[:])
+    time.Sleep(100 * time.Millisecond)
+
+    done := make(chan error, 1)
+    go func() {
+        done <- h.shutdown()
+    }()
+
+    select {
+        case <- done:
+        case <- time.After(3 * time.Second):
+            t.Fatal("Shutdown() blocked by stuck writer")
+    }
+}